@@ -30,17 +30,31 @@ func main() {
 		"version": version,
 	}).Info("build information")
 
+	application := app.NewApp(context.Background(), *cfg)
 	server := server.NewServer(
 		version,
 		cfg.Port,
-		app.NewApp(context.Background(), *cfg),
+		application,
+		server.AuthOptions{
+			APIKeys:       cfg.APIKeys,
+			JWKSFile:      cfg.JWKSFile,
+			JWTIssuer:     cfg.JWTIssuer,
+			JWTAudience:   cfg.JWTAudience,
+			RolesClaim:    cfg.JWTRolesClaim,
+			AnonymousRole: cfg.AnonymousRole,
+		},
+		server.RateLimitOptions{
+			Limits:            cfg.RateLimits,
+			TrustProxyHeaders: cfg.TrustProxyHeaders,
+		},
 	)
 	server.Start()
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	signal := <-c
 
 	server.Stop()
+	application.Close()
 	log.Fatalf("Process killed with signal: %v", signal.String())
 }
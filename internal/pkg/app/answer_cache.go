@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	pgv "github.com/pgvector/pgvector-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// AnswerCacheKey is the query looked up in the answer cache; the answer to the query missing in the cache is stored with it
+type AnswerCacheKey struct {
+	query       string
+	options     string
+	queryVector []float32
+}
+
+// answerCacheEnabled tells whether the answers are cached
+func (app *App) answerCacheEnabled() bool {
+	return app.answerCacheTTL > 0
+}
+
+// CachedAnswer looks for the answer given to the past query closest to the query, searched with the same options;
+// the answer is returned when the similarity of the queries is above the cache threshold and the answer has not expired
+func (app *App) CachedAnswer(ctx context.Context, query string, opts SearchOptions) (*model.Answer, AnswerCacheKey, error) {
+	if !app.answerCacheEnabled() {
+		return nil, AnswerCacheKey{}, nil
+	}
+
+	options, err := json.Marshal(opts)
+	if err != nil {
+		return nil, AnswerCacheKey{}, err
+	}
+	queryVector, err := app.embedQuery(ctx, query)
+	if err != nil {
+		log.Error(err)
+		return nil, AnswerCacheKey{}, err
+	}
+	key := AnswerCacheKey{query: query, options: string(options), queryVector: queryVector}
+
+	answer := model.Answer{}
+	err = app.pgpool.QueryRow(ctx, `SELECT answer FROM answer_cache
+	WHERE options = $1 AND expires_at > now() AND vector_dims(embedding) = $3 AND embedding <=> $2 < 1 - $4::float8
+	ORDER BY embedding <=> $2
+	LIMIT 1`, key.options, pgv.NewVector(queryVector), len(queryVector), app.answerCacheThreshold,
+	).Scan(&answer)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, key, nil
+	}
+	if err != nil {
+		log.Error(err)
+		return nil, key, err
+	}
+	return &answer, key, nil
+}
+
+// CacheAnswer stores the answer to the query missing in the cache; the expired answers are dropped on the way
+func (app *App) CacheAnswer(ctx context.Context, key AnswerCacheKey, answer model.Answer) error {
+	if !app.answerCacheEnabled() || len(key.queryVector) == 0 {
+		return nil
+	}
+
+	documents := []string{}
+	for _, source := range answer.Sources {
+		documents = append(documents, source.Filename)
+	}
+
+	if _, err := app.pgpool.Exec(ctx, `DELETE FROM answer_cache WHERE expires_at <= now()`); err != nil {
+		log.Error(err)
+		return err
+	}
+	_, err := app.pgpool.Exec(ctx, `INSERT INTO answer_cache (id, query, options, embedding, answer, documents, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		uuid.New().String(), key.query, key.options, pgv.NewVector(key.queryVector), answer, documents, time.Now().Add(app.answerCacheTTL))
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// invalidateAnswers drops the cached answers based on the file, as well as the ones not based on any file, which the file
+// may answer now; called when the file is indexed or deleted
+func invalidateAnswers(ctx context.Context, db dbtx, fileName string) error {
+	_, err := db.Exec(ctx, `DELETE FROM answer_cache WHERE $1 = ANY(documents) OR cardinality(documents) = 0`, fileName)
+	return err
+}
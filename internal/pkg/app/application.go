@@ -6,76 +6,239 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
 	"github.com/arkadyb/climate_mate/internal/pkg/config"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	pgv "github.com/pgvector/pgvector-go"
 	log "github.com/sirupsen/logrus"
 	"github.com/tmc/langchaingo/documentloaders"
 	"github.com/tmc/langchaingo/embeddings"
 	"github.com/tmc/langchaingo/llms"
-	"github.com/tmc/langchaingo/llms/googleai"
 	"github.com/tmc/langchaingo/schema"
-	"github.com/tmc/langchaingo/textsplitter"
-	"github.com/tmc/langchaingo/vectorstores"
 	"github.com/tmc/langchaingo/vectorstores/pgvector"
 	"golang.org/x/exp/slices"
 )
 
 const (
-	chunkSize    int = 1000
-	chunkOverlap int = 100
-
-	numberOfNamespacesToSearchIn int = 2
+	// number of chunks embedded at once while indexing a file
+	indexBatchSize int = 100
 
 	DefaultCollectionName       string = "langchain"
 	MetadataCollectionFieldName string = "collection_name"
+	// the collection metadata field keeping the chunking options the file was indexed with
+	MetadataChunkingFieldName string = "chunking"
 )
 
 type SearchStrategy int
 
 const (
-	// this strategy drives to search a top N of documents across <numberOfNamespacesToSearchIn> collections and selects a top N documents based on the score
+	// this strategy drives to search a top N of documents across the routed collections and selects a top N documents based on the score
 	SearchStrategyTopFirst SearchStrategy = iota
-	// this strategy drives to seach a top N of documents across <numberOfNamespacesToSearchIn> collections and selects top N/<numberOfNamespacesToSearchIn> from each
+	// this strategy drives to seach a top N of documents across the routed collections and selects top N/<number of the routed collections> from each;
+	// the share a collection can not fill goes to the others
 	SearchStrategyWide
+	// this strategy drives to search a top N of documents across the routed collections both by similarity and by keywords
+	// and fuses the rankings with the reciprocal rank fusion; the score is the fused one, higher is better
+	SearchStrategyHybrid
+	// this strategy drives to search the candidates across the routed collections and selects top N balancing the relevance
+	// to the query against the similarity to the pages selected already (maximal marginal relevance), so the near duplicates
+	// of the overlapping chunks do not take several places
+	SearchStrategyMMR
 )
 
+// the mmr lambda used when the request does not set one; balances the relevance and the diversity evenly
+const DefaultMMRLambda float32 = 0.5
+
+// SearchOptions drive how the pages are searched
+type SearchOptions struct {
+	Strategy SearchStrategy
+	Routing  RoutingOptions
+	// the least similarity (1 - the cosine distance) to the query of both the routing entries and the pages; 0 keeps them all.
+	// The keyword ranking of the hybrid strategy is not affected
+	ScoreThreshold float32
+	// all the filters must match both the routing entries and the pages
+	Filters []MetadataFilter
+	// the weight of the relevance against the diversity in the mmr strategy, between 0 and 1; 1 ranks by the relevance only
+	MMRLambda float32
+}
+
+var ErrInvalidSearchOptions = errors.New("invalid search options")
+
+// MaxSearchResults returns the most pages a search returns and the most files a request routes the query to
+func (app *App) MaxSearchResults() int {
+	return app.maxSearchResults
+}
+
+// DefaultSearchOptions returns the server defaults used for the search parameters a request does not set
+func (app *App) DefaultSearchOptions() SearchOptions {
+	return SearchOptions{
+		Strategy:       SearchStrategyTopFirst,
+		Routing:        app.routing,
+		ScoreThreshold: app.scoreThreshold,
+		MMRLambda:      DefaultMMRLambda,
+	}
+}
+
+// ValidateSearchOptions checks the options are within the bounds; the error wraps ErrInvalidSearchOptions or ErrInvalidRoutingOptions
+func ValidateSearchOptions(opts SearchOptions) error {
+	if opts.ScoreThreshold < 0 || opts.ScoreThreshold > 1 {
+		return fmt.Errorf("%w: score threshold must be between 0 and 1", ErrInvalidSearchOptions)
+	}
+	if opts.MMRLambda < 0 || opts.MMRLambda > 1 {
+		return fmt.Errorf("%w: mmr lambda must be between 0 and 1", ErrInvalidSearchOptions)
+	}
+	return ValidateRoutingOptions(opts.Routing)
+}
+
 func NewApp(ctx context.Context, cfg config.Config) *App {
-	llm, err := googleai.New(ctx, googleai.WithAPIKey(cfg.GoogleAiApiKey))
+	llm, err := NewLLM(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the calls on behalf of the clients are charged to their daily budget
+	llmBudget := newLLMBudget(cfg.LLMDailyCalls, cfg.LLMDailyTokens)
+	llm = &meteredLLM{Model: llm, budget: llmBudget}
+
+	embedderClient, err := NewEmbedderClient(ctx, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	poolConfig, err := pgxpool.ParseConfig(fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", cfg.PGUserName, cfg.PGPassword, cfg.PGHost, cfg.PGPort, cfg.PGDBName))
 	if err != nil {
 		log.Fatal(err)
 	}
+	poolConfig.MaxConns = int32(cfg.PGMaxConns)
+	poolConfig.MinConns = int32(cfg.PGMinConns)
+	poolConfig.MaxConnLifetime = cfg.PGMaxConnLifetime
+	poolConfig.MaxConnIdleTime = cfg.PGMaxConnIdleTime
+	poolConfig.HealthCheckPeriod = cfg.PGHealthCheckPeriod
 
-	conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", cfg.PGUserName, cfg.PGPassword, cfg.PGHost, cfg.PGPort, cfg.PGDBName))
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
-	return &App{
-		llm:            llm,
-		embedderClient: llm,
-		pgconn:         conn,
+	if err := pool.Ping(ctx); err != nil {
+		log.Fatal(err)
+	}
+	chunking := model.ChunkingOptions{
+		Strategy:     cfg.ChunkStrategy,
+		ChunkSize:    cfg.ChunkSize,
+		ChunkOverlap: cfg.ChunkOverlap,
+		MinChunkSize: cfg.MinChunkSize,
+	}
+	if err := probeTokenEncoding(); err != nil {
+		log.Warnf("the token chunking strategy is not available: %v", err)
+	}
+	if err := validateChunkingOptions(chunking); err != nil {
+		log.Fatal(err)
+	}
+
+	routing := RoutingOptions{
+		Depth:      cfg.RoutingDepth,
+		Namespaces: cfg.RoutingNamespaces,
+		MaxScore:   float32(cfg.RoutingMaxScore),
+		All:        cfg.SearchAllCollections,
+	}
+	if err := ValidateSearchOptions(SearchOptions{Routing: routing, ScoreThreshold: float32(cfg.ScoreThreshold)}); err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.MaxUploadSize < 1 {
+		log.Fatal("max upload size must be at least 1 MiB")
+	}
+
+	if cfg.MaxSearchResults < 1 {
+		log.Fatal("max search results must be positive")
+	}
+
+	if cfg.AnswerCacheThreshold <= 0 || cfg.AnswerCacheThreshold > 1 {
+		log.Fatal("answer cache threshold must be above 0 and at most 1")
+	}
+
+	reranker, err := newReranker(cfg.Reranker, llm)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app := &App{
+		llm:                  llm,
+		llmBudget:            llmBudget,
+		embedderClient:       embedderClient,
+		pgpool:               pool,
+		jobsNotify:           make(chan struct{}, 1),
+		reranker:             reranker,
+		chunking:             chunking,
+		maxUploadSize:        int64(cfg.MaxUploadSize) << 20,
+		routingEntries:       cfg.RoutingEntries,
+		routing:              routing,
+		maxSearchResults:     cfg.MaxSearchResults,
+		scoreThreshold:       float32(cfg.ScoreThreshold),
+		answerCacheTTL:       cfg.AnswerCacheTTL,
+		answerCacheThreshold: cfg.AnswerCacheThreshold,
+		conversationTTL:      cfg.ConversationTTL,
+		embeddingModel:       cfg.EmbeddingProvider + "/" + cfg.EmbeddingModel,
+	}
+	// make sure the langchain tables exist before they are queried directly
+	_, release, err := app.createVectorStore(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	release()
+	if err := app.migrate(ctx); err != nil {
+		log.Fatal(err)
 	}
+	go app.buildIndexes(ctx)
+	if err := app.startIngestionWorkers(ctx, cfg.IngestionWorkers); err != nil {
+		log.Fatal(err)
+	}
+	return app
 }
 
 type App struct {
 	llm            llms.Model
+	llmBudget      *llmBudget
 	embedderClient embeddings.EmbedderClient
-	pgconn         *pgx.Conn
+	pgpool         *pgxpool.Pool
+	jobsNotify     chan struct{}
+	reranker       Reranker
+	chunking       model.ChunkingOptions
+	maxUploadSize  int64
+	routingEntries bool
+	routing        RoutingOptions
+	scoreThreshold float32
+	// the most pages a search returns and the most files a request routes the query to
+	maxSearchResults int
+	// the answers are not cached when the ttl is 0
+	answerCacheTTL       time.Duration
+	answerCacheThreshold float64
+	// the conversations are kept forever when the ttl is 0
+	conversationTTL time.Duration
+	// the provider and the model the embeddings are cached by; the provider default model is cached by the provider only
+	embeddingModel string
+}
+
+// Close closes the database connections pool
+func (app *App) Close() {
+	app.pgpool.Close()
 }
 
-func loadAndSplit(ctx context.Context, contentReader *strings.Reader, minChunkToIndexSize int) ([]schema.Document, error) {
+// loadAndSplit splits the text into the chunks carrying their character offsets in the text
+func loadAndSplit(ctx context.Context, text string, opts model.ChunkingOptions) ([]schema.Document, error) {
+	splitter, err := newTextSplitter(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	docs := []schema.Document{}
-	var err error
-	documentText := documentloaders.NewText(contentReader)
-	if contentReader.Size() > int64(chunkOverlap) {
-		docs, err = documentText.LoadAndSplit(
-			ctx,
-			textsplitter.NewRecursiveCharacter(
-				textsplitter.WithChunkSize(chunkSize),
-				textsplitter.WithChunkOverlap(chunkOverlap),
-			),
-		)
+	minChunkToIndexSize := opts.MinChunkSize
+	documentText := documentloaders.NewText(strings.NewReader(text))
+	if len(text) > opts.ChunkOverlap {
+		docs, err = documentText.LoadAndSplit(ctx, splitter)
 		if err != nil {
 			log.Error(err)
 			return nil, err
@@ -88,6 +251,8 @@ func loadAndSplit(ctx context.Context, contentReader *strings.Reader, minChunkTo
 		}
 	}
 
+	setChunkOffsets(docs, text, maxOverlapChars(opts))
+
 	cleanedDocs := []schema.Document{}
 	// preformat the docs - remove new lines and special chars
 	// cleanout the docs < than minChunkToIndexSize; skip if 0
@@ -100,118 +265,347 @@ func loadAndSplit(ctx context.Context, contentReader *strings.Reader, minChunkTo
 	return cleanedDocs, nil
 }
 
-func (app *App) IndexSummaryForFile(ctx context.Context, fileName string, contentReader *strings.Reader) error {
-	docs, err := loadAndSplit(ctx, contentReader, 0)
+// IndexRequest is the document given to IndexDocument
+type IndexRequest struct {
+	FileName string
+	Summary  string
+	// the summary is written by the llm
+	SummaryGenerated bool
+	// the questions, keywords and section summaries routing the queries to the file next to the summary
+	Routing  []model.RoutingEntry
+	Text     DocumentText
+	Chunking model.ChunkingOptions
+}
+
+// IndexDocument indexes the summary, together with the routing entries which route the queries to the file the same way, into the
+// default collection and the file body into the collection named same as the file. The summaries written by the llm are marked as
+// generated, so they can be reviewed, and the body chunks carry the pages, the heading and the character offsets they come from
+// in their metadata. Everything is embedded first and then written in a single transaction, so readers see either the previous
+// or the new version of the document and a failure leaves the previous version in place. When reindexing, only the body chunks
+// not indexed yet with the same embedding model are embedded and inserted, the chunks no longer in the body are deleted and
+// the unchanged ones are kept; the numbers are returned. Progress is optional and reports the indexed chunks of the body out of
+// all of them, the kept ones included.
+func (app *App) IndexDocument(ctx context.Context, req IndexRequest, progress func(indexed, cached, total int)) (model.IndexStats, error) {
+	if len(req.FileName) == 0 || req.FileName == DefaultCollectionName {
+		return model.IndexStats{}, errors.New("invalid collection name")
+	}
+	if err := validateChunkingOptions(req.Chunking); err != nil {
+		return model.IndexStats{}, err
+	}
+	if err := ValidateTags(req.Text.Tags); err != nil {
+		return model.IndexStats{}, err
+	}
+
+	// index the summary regardless of the size, split the server default way
+	summaryChunking := app.chunking
+	summaryChunking.MinChunkSize = 0
+	summaryDocs, err := loadAndSplit(ctx, req.Summary, summaryChunking)
+	if err != nil {
+		log.Error(err)
+		return model.IndexStats{}, err
+	}
+	// index the body when the page size is at least the min chunk size in length
+	fileDocs, err := loadAndSplit(ctx, req.Text.Body, req.Chunking)
 	if err != nil {
 		log.Error(err)
-		return err
+		return model.IndexStats{}, err
 	}
 
-	// set metadata
-	metadata := map[string]any{
-		MetadataCollectionFieldName: fileName,
+	// set metadata; the summary offsets are of no use
+	for i := 0; i < len(summaryDocs); i++ {
+		summaryDocs[i].Metadata = map[string]any{
+			MetadataCollectionFieldName:       req.FileName,
+			MetadataRoutingKindFieldName:      RoutingKindSummary,
+			MetadataSummaryGeneratedFieldName: req.SummaryGenerated,
+		}
 	}
-	for i := 0; i < len(docs); i++ {
-		docs[i].Metadata = metadata
+	summaryDocs = dedupDocuments(append(summaryDocs, routingDocuments(req.FileName, req.Routing)...))
+	annotateChunks(fileDocs, req.Text)
+	for i := 0; i < len(fileDocs); i++ {
+		fileDocs[i].Metadata[MetadataCollectionFieldName] = req.FileName
+		fileDocs[i].Metadata[MetadataEmbeddingModelFieldName] = app.embeddingModel
+	}
+	// the tags are copied into every chunk and routing entry so the search filters can match them
+	for _, docs := range [][]schema.Document{summaryDocs, fileDocs} {
+		for i := range docs {
+			if len(req.Text.SourceURL) > 0 {
+				docs[i].Metadata[MetadataSourceURLFieldName] = req.Text.SourceURL
+			}
+			if len(req.Text.Tags) > 0 {
+				docs[i].Metadata[MetadataTagsFieldName] = req.Text.Tags
+			}
+		}
 	}
+	fileDocs = dedupDocuments(fileDocs)
 
-	// when reindexing - check to remove the existing sumary embeddings in the default collection
-	_, err = app.pgconn.Exec(ctx, `DELETE
-	FROM langchain_pg_embedding AS emb USING langchain_pg_collection AS coll 
-	WHERE coll.name=$1 AND emb.cmetadata ->> 'collection_name' = $2`, DefaultCollectionName, fileName)
+	if err := app.pruneEmbeddingCache(ctx); err != nil {
+		log.Error(err)
+		return model.IndexStats{}, err
+	}
+	summaryVectors, err := app.embedText(ctx, summaryDocs, nil)
+	if err != nil {
+		log.Error(err)
+		return model.IndexStats{}, err
+	}
+	// only the chunks not indexed yet are embedded; the indexed ones are read again under the lock below
+	indexed, err := indexedChunks(ctx, app.pgpool, req.FileName)
 	if err != nil {
-		return err
+		log.Error(err)
+		return model.IndexStats{}, err
+	}
+	addedDocs := newChunks(fileDocs, indexed)
+	// the kept chunks are not embedded again, so they count as indexed and found in the cache
+	var embedProgress func(indexed, cached, total int)
+	if progress != nil {
+		kept := len(fileDocs) - len(addedDocs)
+		progress(kept, kept, len(fileDocs))
+		embedProgress = func(indexed, cached, _ int) {
+			progress(kept+indexed, kept+cached, len(fileDocs))
+		}
+	}
+	addedVectors, err := app.embedText(ctx, addedDocs, embedProgress)
+	if err != nil {
+		log.Error(err)
+		return model.IndexStats{}, err
+	}
+	vectors := map[string][]float32{}
+	for i, doc := range addedDocs {
+		vectors[contentHash(doc.PageContent)] = addedVectors[i]
 	}
 
-	store, err := app.createVectorStore(ctx)
+	tx, err := app.pgpool.Begin(ctx)
 	if err != nil {
-		return err
+		log.Error(err)
+		return model.IndexStats{}, err
 	}
+	defer tx.Rollback(ctx)
 
-	return app.indexText(ctx, store, docs)
-}
+	// serialize concurrent indexing of the same file
+	if err := lockDocument(ctx, tx, req.FileName); err != nil {
+		log.Error(err)
+		return model.IndexStats{}, err
+	}
 
-func (app *App) IndexFile(ctx context.Context, fileName string, contentReader *strings.Reader) error {
-	docs, err := loadAndSplit(ctx, contentReader, 250)
+	// when reindexing - remove the existing summary and routing embeddings in the default collection; the file collection is updated
+	if _, err := deleteSummaries(ctx, tx, req.FileName); err != nil {
+		log.Error(err)
+		return model.IndexStats{}, err
+	}
+	if err := invalidateAnswers(ctx, tx, req.FileName); err != nil {
+		log.Error(err)
+		return model.IndexStats{}, err
+	}
+
+	collectionMetadata := map[string]any{
+		MetadataChunkingFieldName:         req.Chunking,
+		MetadataSummaryGeneratedFieldName: req.SummaryGenerated,
+		MetadataTagsFieldName:             req.Text.Tags,
+	}
+	collectionID, err := upsertCollection(ctx, tx, req.FileName, uuid.New().String(), collectionMetadata)
 	if err != nil {
 		log.Error(err)
-		return err
+		return model.IndexStats{}, err
+	}
+	var defaultCollectionID string
+	if err := tx.QueryRow(ctx, `SELECT uuid FROM langchain_pg_collection WHERE name = $1`, DefaultCollectionName).Scan(&defaultCollectionID); err != nil {
+		log.Error(err)
+		return model.IndexStats{}, err
 	}
 
-	// set metadata
-	metadata := map[string]any{
-		MetadataCollectionFieldName: fileName,
+	if err := indexText(ctx, tx, defaultCollectionID, summaryDocs, summaryVectors); err != nil {
+		log.Error(err)
+		return model.IndexStats{}, err
 	}
-	for i := 0; i < len(docs); i++ {
-		docs[i].Metadata = metadata
+	indexed, err = indexedChunks(ctx, tx, req.FileName)
+	if err != nil {
+		log.Error(err)
+		return model.IndexStats{}, err
+	}
+	stats, err := app.updateChunks(ctx, tx, collectionID, fileDocs, indexed, vectors)
+	if err != nil {
+		log.Error(err)
+		return model.IndexStats{}, err
 	}
 
-	store, err := app.createVectorStoreByName(ctx, fileName)
+	return stats, tx.Commit(ctx)
+}
+
+// embedQuery embeds the query the way the docs are embedded
+func (app *App) embedQuery(ctx context.Context, query string) ([]float32, error) {
+	emb, err := embeddings.NewEmbedder(app.embedderClient, embeddings.WithStripNewLines(true))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return emb.EmbedQuery(ctx, query)
+}
 
-	err = app.indexText(ctx, store, docs)
+// embedText embeds the docs in batches, reporting the number of embedded docs and the ones found in the embedding cache to progress
+// after each batch; progress is optional. Only the docs missing in the cache are sent to the embedder
+func (app *App) embedText(ctx context.Context, docs []schema.Document, progress func(indexed, cached, total int)) ([][]float32, error) {
+	emb, err := embeddings.NewEmbedder(app.embedderClient, embeddings.WithStripNewLines(true))
 	if err != nil {
-		log.Error(err)
-		return err
+		return nil, err
 	}
 
-	return nil
+	vectors := make([][]float32, 0, len(docs))
+	cached := 0
+	for start := 0; start < len(docs); start += indexBatchSize {
+		end := start + indexBatchSize
+		if end > len(docs) {
+			end = len(docs)
+		}
+		hashes := make([]string, 0, end-start)
+		for _, doc := range docs[start:end] {
+			hashes = append(hashes, contentHash(doc.PageContent))
+		}
+		batchVectors, err := app.cachedEmbeddings(ctx, hashes)
+		if err != nil {
+			return nil, err
+		}
+
+		texts := []string{}
+		missingHashes := []string{}
+		for i, doc := range docs[start:end] {
+			if _, ok := batchVectors[hashes[i]]; !ok {
+				texts = append(texts, doc.PageContent)
+				missingHashes = append(missingHashes, hashes[i])
+			}
+		}
+		if len(texts) > 0 {
+			missingVectors, err := emb.EmbedDocuments(ctx, texts)
+			if err != nil {
+				return nil, err
+			}
+			if len(missingVectors) != len(texts) {
+				return nil, errors.New("number of vectors from embedder does not match number of documents")
+			}
+			if err := app.cacheEmbeddings(ctx, missingHashes, missingVectors); err != nil {
+				return nil, err
+			}
+			for i, hash := range missingHashes {
+				batchVectors[hash] = missingVectors[i]
+			}
+		}
+
+		for _, hash := range hashes {
+			vectors = append(vectors, batchVectors[hash])
+		}
+		cached += len(hashes) - len(texts)
+		if progress != nil {
+			progress(end, cached, len(docs))
+		}
+	}
+	return vectors, nil
 }
 
-func (app *App) indexText(ctx context.Context, store *pgvector.Store, docs []schema.Document) error {
+// indexText writes the embedded docs into the collection as part of the transaction
+func indexText(ctx context.Context, tx pgx.Tx, collectionID string, docs []schema.Document, vectors [][]float32) error {
+	b := &pgx.Batch{}
+	for i, doc := range docs {
+		b.Queue(`INSERT INTO langchain_pg_embedding (uuid, document, embedding, cmetadata, collection_id) VALUES ($1, $2, $3, $4, $5)`,
+			uuid.New().String(), doc.PageContent, pgv.NewVector(vectors[i]), doc.Metadata, collectionID)
+	}
+	return tx.SendBatch(ctx, b).Close()
+}
+
+// dedupDocuments skips the exact duplicates of the docs
+func dedupDocuments(docs []schema.Document) []schema.Document {
 	dedupMap := make(map[string]struct{})
-	dedup := func(ctx context.Context, doc schema.Document) bool {
+	dedupedDocs := make([]schema.Document, 0, len(docs))
+	for _, doc := range docs {
 		if _, ok := dedupMap[doc.PageContent]; ok {
-			return true // skip duplicated for the doc
+			continue // skip duplicated for the doc
 		}
 		dedupMap[doc.PageContent] = struct{}{}
-		return false
+		dedupedDocs = append(dedupedDocs, doc)
 	}
-	_, err := store.AddDocuments(ctx, docs, vectorstores.WithDeduplicater(dedup))
-	if err != nil {
-		return err
+	return dedupedDocs
+}
+
+// wideSelection takes the top docs of each namespace in turns, so every namespace gets an equal share of n, the namespaces
+// first in order get the remainder, and the share a namespace has no docs for is taken by the others; returns n docs unless
+// there are fewer docs in total
+func wideSelection(namespacesDocs [][]schema.Document, n int) []schema.Document {
+	selected := []schema.Document{}
+	for rank := 0; len(selected) < n; rank++ {
+		taken := false
+		for _, namespaceDocs := range namespacesDocs {
+			if rank < len(namespaceDocs) && len(selected) < n {
+				selected = append(selected, namespaceDocs[rank])
+				taken = true
+			}
+		}
+		if !taken {
+			break
+		}
 	}
-	return nil
+	return selected
 }
 
-func (app *App) Search(ctx context.Context, query string, numDocuments int, searchStrategy SearchStrategy) (model.SearchResults, error) {
-	store, err := app.createVectorStore(ctx)
+// wideSearch does the search across in the all the target namespaces, in the routing order, and gives each namespace a fair share
+// of the docs; the docs are sorted by the score
+func wideSearch(ctx context.Context, searcher namespaceSearcher, namespaces []string, queryVector []float32, numDocuments int, opts SearchOptions) ([]schema.Document, error) {
+	namespacesDocs := [][]schema.Document{}
+	for _, namespace := range namespaces {
+		// a namespace may need to fill the share the others could not
+		namespaceDocs, _, err := searcher.similaritySearch(ctx, namespace, queryVector, numDocuments, opts)
+		if err != nil {
+			return nil, err
+		}
+		namespacesDocs = append(namespacesDocs, namespaceDocs)
+	}
+	docs := wideSelection(namespacesDocs, numDocuments)
+	slices.SortFunc(docs, func(a, b schema.Document) int {
+		if a.Score < b.Score {
+			return -1
+		} else if a.Score > b.Score {
+			return 1
+		}
+		return 0
+	})
+	return docs, nil
+}
+
+// Search looks for the pages matching the query in the file collections the query is routed to
+func (app *App) Search(ctx context.Context, query string, numDocuments int, opts SearchOptions) (model.SearchResults, error) {
+	if err := ValidateSearchOptions(opts); err != nil {
+		return model.SearchResults{}, err
+	}
+
+	// the query is embedded once for the routing and the search in all the files
+	queryVector, err := app.embedQuery(ctx, query)
 	if err != nil {
 		log.Error(err)
 		return model.SearchResults{}, err
 	}
 
-	// run initial search in the default namespace - take the best matching the query
-	defaultNamespaceDocs, err := store.SimilaritySearch(ctx, query, numberOfNamespacesToSearchIn)
+	// run initial search in the default namespace - take the files best matching the query
+	namespaces, err := app.routeQuery(ctx, queryVector, opts)
 	if err != nil {
 		log.Error(err)
 		return model.SearchResults{}, err
 	}
 
 	uniqueNamespacesMap := map[string]struct{}{}
-	for _, defaultNamedefaultNamespaceDoc := range defaultNamespaceDocs {
-		namespace := defaultNamedefaultNamespaceDoc.Metadata[MetadataCollectionFieldName].(string)
-		if _, ok := uniqueNamespacesMap[namespace]; !ok {
-			uniqueNamespacesMap[namespace] = struct{}{}
-		}
+	for _, namespace := range namespaces {
+		uniqueNamespacesMap[namespace] = struct{}{}
 	}
 
 	docs := []schema.Document{}
 
-	switch searchStrategy {
+	switch opts.Strategy {
 	case SearchStrategyTopFirst:
 		// do the search across in the all the target namespaces and merge the results by score
 		for namespace := range uniqueNamespacesMap {
-			namespaceDocs, err := store.SimilaritySearch(ctx, query, numDocuments, vectorstores.WithNameSpace(namespace))
+			namespaceDocs, _, err := app.similaritySearch(ctx, namespace, queryVector, numDocuments, opts)
 			if err != nil {
 				log.Error(err)
 				return model.SearchResults{}, err
 			}
 			docs = append(docs, namespaceDocs...)
 		}
-		// TODO: switch over to use merge strategy instead
+		// the distances of the namespaces are comparable as they come from the same embedding model; the reranker, when configured,
+		// is the merge strategy reordering the pages by their relevance to the query
 		slices.SortFunc(docs, func(a, b schema.Document) int {
 			if a.Score < b.Score {
 				return -1
@@ -221,25 +615,47 @@ func (app *App) Search(ctx context.Context, query string, numDocuments int, sear
 			return 0
 		})
 	case SearchStrategyWide:
-		// do the search across in the all the target namespaces and merge the results by score
+		docs, err = wideSearch(ctx, app, namespaces, queryVector, numDocuments, opts)
+		if err != nil {
+			log.Error(err)
+			return model.SearchResults{}, err
+		}
+	case SearchStrategyHybrid:
+		// do the similarity and the keyword search in the all the target namespaces and fuse the rankings
 		for namespace := range uniqueNamespacesMap {
-			namespaceDocs, err := store.SimilaritySearch(ctx, query, numDocuments, vectorstores.WithNameSpace(namespace))
+			namespaceDocs, _, err := app.similaritySearch(ctx, namespace, queryVector, numDocuments, opts)
 			if err != nil {
 				log.Error(err)
 				return model.SearchResults{}, err
 			}
-			if len(namespaceDocs) > 0 {
-				docs = append(docs, namespaceDocs[:len(namespaceDocs)/len(defaultNamespaceDocs)]...)
+			keywordDocs, err := app.keywordSearch(ctx, namespace, query, numDocuments, opts.Filters)
+			if err != nil {
+				log.Error(err)
+				return model.SearchResults{}, err
 			}
+			docs = append(docs, fuseRankings(namespaceDocs, keywordDocs)...)
 		}
 		slices.SortFunc(docs, func(a, b schema.Document) int {
-			if a.Score < b.Score {
+			if a.Score > b.Score {
 				return -1
-			} else if a.Score > b.Score {
+			} else if a.Score < b.Score {
 				return 1
 			}
 			return 0
 		})
+	case SearchStrategyMMR:
+		// pick from a wider set of the candidates across the all the target namespaces, so there is room for the diversity
+		vectors := [][]float32{}
+		for namespace := range uniqueNamespacesMap {
+			namespaceDocs, namespaceVectors, err := app.similaritySearch(ctx, namespace, queryVector, numDocuments*mmrCandidatesFactor, opts)
+			if err != nil {
+				log.Error(err)
+				return model.SearchResults{}, err
+			}
+			docs = append(docs, namespaceDocs...)
+			vectors = append(vectors, namespaceVectors...)
+		}
+		docs = maximalMarginalRelevance(docs, vectors, numDocuments, opts.MMRLambda)
 	}
 
 	pageResults := []model.SearchResultsEntry{}
@@ -251,6 +667,13 @@ func (app *App) Search(ctx context.Context, query string, numDocuments int, sear
 			Filename:    doc.Metadata[MetadataCollectionFieldName].(string),
 			PageContent: doc.PageContent,
 			Score:       doc.Score,
+			Page:        metadataInt(doc.Metadata, MetadataPageFieldName),
+			PageEnd:     metadataInt(doc.Metadata, MetadataPageEndFieldName),
+			Heading:     metadataString(doc.Metadata, MetadataHeadingFieldName),
+			OffsetStart: metadataInt(doc.Metadata, MetadataOffsetStartFieldName),
+			OffsetEnd:   metadataInt(doc.Metadata, MetadataOffsetEndFieldName),
+			SourceURL:   metadataString(doc.Metadata, MetadataSourceURLFieldName),
+			Tags:        metadataTags(doc.Metadata),
 		})
 	}
 
@@ -285,45 +708,47 @@ func (app *App) GenerateFromParts(ctx context.Context, prompts []string) (string
 	return "", nil
 }
 
-func (app *App) createVectorStoreByName(ctx context.Context, collectionName string) (*pgvector.Store, error) {
-	if len(collectionName) == 0 {
-		return nil, errors.New("collection name is required")
-	}
-	emb, err := embeddings.NewEmbedder(app.embedderClient, embeddings.WithStripNewLines(true))
+// GenerateFromPartsStream works as GenerateFromParts, but hands the answer over to streamingFunc chunk by chunk as the model produces it
+func (app *App) GenerateFromPartsStream(ctx context.Context, prompts []string, streamingFunc func(ctx context.Context, chunk []byte) error) (string, error) {
+	resp, err := app.llm.GenerateContent(ctx, []llms.MessageContent{
+		llms.TextParts(llms.ChatMessageTypeHuman, prompts...),
+	}, llms.WithStreamingFunc(streamingFunc))
 	if err != nil {
-		return nil, err
+		log.Error(err)
+		return "", err
 	}
-	pgVectorStore, err := pgvector.New(
-		ctx,
-		pgvector.WithCollectionName(collectionName),
-		pgvector.WithPreDeleteCollection(true),
-		pgvector.WithConn(app.pgconn),
-		pgvector.WithEmbedder(emb),
-	)
-	if err != nil {
-		return nil, err
+
+	if resp != nil && len(resp.Choices) > 0 {
+		return resp.Choices[0].Content, nil
 	}
 
-	return &pgVectorStore, nil
+	return "", nil
 }
 
-func (app *App) createVectorStore(ctx context.Context) (*pgvector.Store, error) {
+// createVectorStore binds the store to a connection acquired from the pool as the store does not support the pool itself;
+// the release func must be called once the store is no longer used
+func (app *App) createVectorStore(ctx context.Context) (*pgvector.Store, func(), error) {
 	emb, err := embeddings.NewEmbedder(app.embedderClient, embeddings.WithStripNewLines(true))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	conn, err := app.pgpool.Acquire(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
 	pgVectorStore, err := pgvector.New(
 		ctx,
 		pgvector.WithCollectionName(DefaultCollectionName),
-		pgvector.WithConn(app.pgconn),
+		pgvector.WithConn(conn.Conn()),
 		pgvector.WithEmbedder(emb),
 	)
 
 	if err != nil {
-		return nil, err
+		conn.Release()
+		return nil, nil, err
 	}
 
-	return &pgVectorStore, nil
+	return &pgVectorStore, conn.Release, nil
 }
 
 func removeLBR(text string) string {
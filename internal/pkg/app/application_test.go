@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// namespaceDocs returns n docs of the namespace named by the namespace and the rank, with the score growing with the rank
+func namespaceDocs(namespace string, n int) []schema.Document {
+	docs := []schema.Document{}
+	for rank := 0; rank < n; rank++ {
+		docs = append(docs, schema.Document{
+			PageContent: fmt.Sprintf("%s%d", namespace, rank),
+			Score:       float32(rank+1) / 10,
+			Metadata:    map[string]any{MetadataCollectionFieldName: namespace},
+		})
+	}
+	return docs
+}
+
+func docNames(docs []schema.Document) string {
+	names := []string{}
+	for _, doc := range docs {
+		names = append(names, doc.PageContent)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestWideSelection(t *testing.T) {
+	tests := []struct {
+		name           string
+		namespacesDocs [][]schema.Document
+		n              int
+		selected       string
+	}{
+		{
+			name:           "even split",
+			namespacesDocs: [][]schema.Document{namespaceDocs("a", 5), namespaceDocs("b", 5)},
+			n:              4,
+			selected:       "a0,b0,a1,b1",
+		},
+		{
+			name:           "uneven split gives the remainder to the first namespaces",
+			namespacesDocs: [][]schema.Document{namespaceDocs("a", 5), namespaceDocs("b", 5), namespaceDocs("c", 5)},
+			n:              5,
+			selected:       "a0,b0,c0,a1,b1",
+		},
+		{
+			name:           "empty namespace",
+			namespacesDocs: [][]schema.Document{namespaceDocs("a", 3), {}, namespaceDocs("c", 3)},
+			n:              4,
+			selected:       "a0,c0,a1,c1",
+		},
+		{
+			name:           "unused quota is redistributed",
+			namespacesDocs: [][]schema.Document{namespaceDocs("a", 1), namespaceDocs("b", 5), namespaceDocs("c", 2)},
+			n:              6,
+			selected:       "a0,b0,c0,b1,c1,b2",
+		},
+		{
+			name:           "fewer docs than n in total",
+			namespacesDocs: [][]schema.Document{namespaceDocs("a", 1), namespaceDocs("b", 2)},
+			n:              5,
+			selected:       "a0,b0,b1",
+		},
+		{
+			name:           "exactly n docs in total",
+			namespacesDocs: [][]schema.Document{namespaceDocs("a", 2), namespaceDocs("b", 1), namespaceDocs("c", 1)},
+			n:              4,
+			selected:       "a0,b0,c0,a1",
+		},
+		{
+			name:           "no namespaces",
+			namespacesDocs: [][]schema.Document{},
+			n:              4,
+			selected:       "",
+		},
+		{
+			name:           "no docs asked",
+			namespacesDocs: [][]schema.Document{namespaceDocs("a", 2)},
+			n:              0,
+			selected:       "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := wideSelection(tt.namespacesDocs, tt.n)
+			if names := docNames(selected); names != tt.selected {
+				t.Errorf("selected %s, want %s", names, tt.selected)
+			}
+		})
+	}
+}
+
+// fakeSearcher serves the docs of the namespaces from memory and records the searches
+type fakeSearcher struct {
+	docs     map[string][]schema.Document
+	err      error
+	searched []string
+}
+
+func (s *fakeSearcher) similaritySearch(_ context.Context, collectionName string, _ []float32, numDocuments int, _ SearchOptions) ([]schema.Document, [][]float32, error) {
+	s.searched = append(s.searched, fmt.Sprintf("%s:%d", collectionName, numDocuments))
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	docs := s.docs[collectionName]
+	if len(docs) > numDocuments {
+		docs = docs[:numDocuments]
+	}
+	return docs, make([][]float32, len(docs)), nil
+}
+
+func TestWideSearch(t *testing.T) {
+	searcher := &fakeSearcher{docs: map[string][]schema.Document{
+		"a": namespaceDocs("a", 1),
+		"b": namespaceDocs("b", 5),
+		"c": namespaceDocs("c", 5),
+	}}
+	// the first namespace in the routing order is not the closest one
+	searcher.docs["a"][0].Score = 0.35
+	for i := range searcher.docs["c"] {
+		searcher.docs["c"][i].Score += 0.05
+	}
+
+	docs, err := wideSearch(context.Background(), searcher, []string{"a", "b", "c", "d"}, []float32{1, 0}, 5, SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every namespace is asked for all the docs, so it can fill the share of the others
+	if searched := strings.Join(searcher.searched, ","); searched != "a:5,b:5,c:5,d:5" {
+		t.Errorf("searched %s, want a:5,b:5,c:5,d:5", searched)
+	}
+	// the docs are sorted by the score, the distance, ascending
+	if names := docNames(docs); names != "b0,c0,b1,c1,a0" {
+		t.Errorf("found %s, want b0,c0,b1,c1,a0", names)
+	}
+}
+
+func TestWideSearchFails(t *testing.T) {
+	searchErr := errors.New("search failed")
+	searcher := &fakeSearcher{err: searchErr}
+
+	if _, err := wideSearch(context.Background(), searcher, []string{"a", "b"}, []float32{1, 0}, 5, SearchOptions{}); !errors.Is(err, searchErr) {
+		t.Errorf("error is '%v', want '%v'", err, searchErr)
+	}
+}
@@ -0,0 +1,153 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// the model the chunk is embedded with; the chunks embedded with another model are changed, so they are embedded again
+const MetadataEmbeddingModelFieldName string = "embedding_model"
+
+// indexedChunk is the chunk in the file collection
+type indexedChunk struct {
+	uuid     string
+	metadata map[string]any
+}
+
+// indexedChunks returns the chunks of the file collection by the chunk key; the same text may be indexed more than once
+// by the older versions of the app
+func indexedChunks(ctx context.Context, db dbtx, fileName string) (map[string][]indexedChunk, error) {
+	rows, err := db.Query(ctx, `SELECT emb.uuid, emb.document, emb.cmetadata
+	FROM langchain_pg_embedding AS emb JOIN langchain_pg_collection AS coll ON emb.collection_id = coll.uuid
+	WHERE coll.name = $1`, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := map[string][]indexedChunk{}
+	for rows.Next() {
+		var document string
+		chunk := indexedChunk{}
+		if err := rows.Scan(&chunk.uuid, &document, &chunk.metadata); err != nil {
+			return nil, err
+		}
+		key := chunkKey(document, chunk.metadata)
+		chunks[key] = append(chunks[key], chunk)
+	}
+	return chunks, rows.Err()
+}
+
+// chunkKey identifies the chunk by the hash of its text and the model it is embedded with; the chunks indexed before the model
+// was kept in the metadata never match
+func chunkKey(text string, metadata map[string]any) string {
+	embeddingModel, _ := metadata[MetadataEmbeddingModelFieldName].(string)
+	return embeddingModel + "|" + contentHash(text)
+}
+
+// newChunks returns the docs whose text is not indexed yet with the same embedding model
+func newChunks(docs []schema.Document, indexed map[string][]indexedChunk) []schema.Document {
+	added := []schema.Document{}
+	for _, doc := range docs {
+		if len(indexed[chunkKey(doc.PageContent, doc.Metadata)]) == 0 {
+			added = append(added, doc)
+		}
+	}
+	return added
+}
+
+// updateChunks turns the indexed chunks of the collection into the docs: the docs not indexed yet are inserted, the chunks
+// no longer among the docs or embedded with another model are deleted and the unchanged ones keep their embeddings, only
+// their metadata is updated.
+// vectors are the embeddings of the new docs by the text hash; the docs missing there are embedded on the way
+func (app *App) updateChunks(ctx context.Context, tx pgx.Tx, collectionID string, docs []schema.Document, indexed map[string][]indexedChunk, vectors map[string][]float32) (model.IndexStats, error) {
+	stats := model.IndexStats{}
+	b := &pgx.Batch{}
+	added := []schema.Document{}
+	for _, doc := range docs {
+		key := chunkKey(doc.PageContent, doc.Metadata)
+		chunks := indexed[key]
+		if len(chunks) == 0 {
+			added = append(added, doc)
+			continue
+		}
+		indexed[key] = chunks[1:]
+		stats.Unchanged++
+		// the edits before the chunk move its offsets and the pages
+		same, err := sameMetadata(chunks[0].metadata, doc.Metadata)
+		if err != nil {
+			return model.IndexStats{}, err
+		}
+		if !same {
+			b.Queue(`UPDATE langchain_pg_embedding SET cmetadata = $1 WHERE uuid = $2`, doc.Metadata, chunks[0].uuid)
+		}
+	}
+
+	removed := []string{}
+	for _, chunks := range indexed {
+		for _, chunk := range chunks {
+			removed = append(removed, chunk.uuid)
+		}
+	}
+	if len(removed) > 0 {
+		b.Queue(`DELETE FROM langchain_pg_embedding WHERE uuid = ANY($1::uuid[])`, removed)
+	}
+	stats.Removed = len(removed)
+	if err := tx.SendBatch(ctx, b).Close(); err != nil {
+		return model.IndexStats{}, err
+	}
+
+	// the chunks removed by a concurrent update after the new docs were picked are embedded now
+	missing := []schema.Document{}
+	for _, doc := range added {
+		if _, ok := vectors[contentHash(doc.PageContent)]; !ok {
+			missing = append(missing, doc)
+		}
+	}
+	missingVectors, err := app.embedText(ctx, missing, nil)
+	if err != nil {
+		return model.IndexStats{}, err
+	}
+	for i, doc := range missing {
+		vectors[contentHash(doc.PageContent)] = missingVectors[i]
+	}
+
+	addedVectors := make([][]float32, 0, len(added))
+	for _, doc := range added {
+		addedVectors = append(addedVectors, vectors[contentHash(doc.PageContent)])
+	}
+	if err := indexText(ctx, tx, collectionID, added, addedVectors); err != nil {
+		return model.IndexStats{}, err
+	}
+	stats.Added = len(added)
+	return stats, nil
+}
+
+// upsertCollection returns the id of the file collection, created when missing, and sets its metadata
+func upsertCollection(ctx context.Context, tx pgx.Tx, fileName, newCollectionID string, metadata map[string]any) (string, error) {
+	var collectionID string
+	err := tx.QueryRow(ctx, `UPDATE langchain_pg_collection SET cmetadata = $1 WHERE name = $2 RETURNING uuid`, metadata, fileName).Scan(&collectionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		_, err = tx.Exec(ctx, `INSERT INTO langchain_pg_collection (uuid, name, cmetadata) VALUES ($1, $2, $3)`, newCollectionID, fileName, metadata)
+		return newCollectionID, err
+	}
+	return collectionID, err
+}
+
+// sameMetadata compares the metadata the way it is stored, as the numbers read back from the json are floats
+func sameMetadata(a, b map[string]any) (bool, error) {
+	aJson, err := json.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+	bJson, err := json.Marshal(b)
+	if err != nil {
+		return false, err
+	}
+	return string(aJson) == string(bJson), nil
+}
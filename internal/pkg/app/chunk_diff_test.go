@@ -0,0 +1,32 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+func TestNewChunks(t *testing.T) {
+	chunk := func(text, embeddingModel string) schema.Document {
+		metadata := map[string]any{MetadataCollectionFieldName: "file"}
+		if len(embeddingModel) > 0 {
+			metadata[MetadataEmbeddingModelFieldName] = embeddingModel
+		}
+		return schema.Document{PageContent: text, Metadata: metadata}
+	}
+	indexed := map[string][]indexedChunk{}
+	for _, doc := range []schema.Document{chunk("kept", "googleai/text-embedding-004"), chunk("other model", "openai/text-embedding-3-small"), chunk("no model", "")} {
+		key := chunkKey(doc.PageContent, doc.Metadata)
+		indexed[key] = append(indexed[key], indexedChunk{metadata: doc.Metadata})
+	}
+
+	docs := []schema.Document{
+		chunk("kept", "googleai/text-embedding-004"),
+		chunk("other model", "googleai/text-embedding-004"),
+		chunk("no model", "googleai/text-embedding-004"),
+		chunk("new", "googleai/text-embedding-004"),
+	}
+	if names := docNames(newChunks(docs, indexed)); names != "other model,no model,new" {
+		t.Errorf("new chunks are %s, want other model,no model,new", names)
+	}
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+const (
+	// splits by paragraphs, lines and words, chunk size and overlap are in characters
+	ChunkingRecursive string = "recursive"
+	// splits by the tiktoken tokens, chunk size and overlap are in tokens
+	ChunkingToken string = "token"
+	// splits by the markdown headings first, then by paragraphs; chunk size and overlap are in characters
+	ChunkingMarkdown string = "markdown"
+	// groups the whole sentences, chunk size and overlap are in characters
+	ChunkingSentence string = "sentence"
+)
+
+// the tiktoken encoding of the token strategy; it is read from TIKTOKEN_CACHE_DIR, or downloaded when not there
+const tokenEncoding = "cl100k_base"
+
+var ErrInvalidChunkingOptions = errors.New("invalid chunking options")
+
+var sentenceRegexp = regexp.MustCompile(`[^.!?]+(?:[.!?]+["')\]]*|$)\s*`)
+
+// the words ending with a period that do not end the sentence
+var abbreviations = map[string]bool{
+	"e.g.": true, "i.e.": true, "cf.": true, "vs.": true, "al.": true, "approx.": true, "ca.": true,
+	"fig.": true, "figs.": true, "eq.": true, "no.": true, "vol.": true, "pp.": true, "sect.": true,
+	"dr.": true, "prof.": true, "mr.": true, "mrs.": true, "ms.": true, "st.": true,
+}
+
+// tokenEncodingErr is why the token strategy can not be used; set by probeTokenEncoding at the start
+var tokenEncodingErr error
+
+// probeTokenEncoding loads the encoding of the token strategy, so a missing encoding fails the start
+// or the upload asking for it rather than the ingestion job
+func probeTokenEncoding() error {
+	if _, err := tiktoken.GetEncoding(tokenEncoding); err != nil {
+		tokenEncodingErr = fmt.Errorf("failed to load the %s encoding: %w", tokenEncoding, err)
+	}
+	return tokenEncodingErr
+}
+
+// DefaultChunkingOptions returns the server defaults used for the parameters an upload does not set
+func (app *App) DefaultChunkingOptions() model.ChunkingOptions {
+	return app.chunking
+}
+
+func validateChunkingOptions(opts model.ChunkingOptions) error {
+	switch opts.Strategy {
+	case ChunkingRecursive, ChunkingToken, ChunkingMarkdown, ChunkingSentence:
+	default:
+		return fmt.Errorf("%w: unknown strategy '%s'", ErrInvalidChunkingOptions, opts.Strategy)
+	}
+	if opts.Strategy == ChunkingToken && tokenEncodingErr != nil {
+		return fmt.Errorf("%w: the token strategy is not available", ErrInvalidChunkingOptions)
+	}
+	if opts.ChunkSize <= 0 {
+		return fmt.Errorf("%w: chunk size must be positive", ErrInvalidChunkingOptions)
+	}
+	if opts.ChunkOverlap < 0 || opts.ChunkOverlap >= opts.ChunkSize {
+		return fmt.Errorf("%w: chunk overlap must be between 0 and the chunk size", ErrInvalidChunkingOptions)
+	}
+	if opts.MinChunkSize < 0 {
+		return fmt.Errorf("%w: min chunk size must not be negative", ErrInvalidChunkingOptions)
+	}
+	return nil
+}
+
+func newTextSplitter(opts model.ChunkingOptions) (textsplitter.TextSplitter, error) {
+	switch opts.Strategy {
+	case ChunkingRecursive:
+		return textsplitter.NewRecursiveCharacter(
+			textsplitter.WithChunkSize(opts.ChunkSize),
+			textsplitter.WithChunkOverlap(opts.ChunkOverlap),
+		), nil
+	case ChunkingToken:
+		return textsplitter.NewTokenSplitter(
+			textsplitter.WithEncodingName(tokenEncoding),
+			textsplitter.WithChunkSize(opts.ChunkSize),
+			textsplitter.WithChunkOverlap(opts.ChunkOverlap),
+		), nil
+	case ChunkingMarkdown:
+		return textsplitter.NewMarkdownTextSplitter(
+			textsplitter.WithChunkSize(opts.ChunkSize),
+			textsplitter.WithChunkOverlap(opts.ChunkOverlap),
+		), nil
+	case ChunkingSentence:
+		return sentenceSplitter{
+			chunkSize:    opts.ChunkSize,
+			chunkOverlap: opts.ChunkOverlap,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown strategy '%s'", ErrInvalidChunkingOptions, opts.Strategy)
+}
+
+// maxOverlapChars is the most characters two neighbour chunks can share; -1 when it is not known in characters
+func maxOverlapChars(opts model.ChunkingOptions) int {
+	if opts.Strategy == ChunkingToken {
+		return -1
+	}
+	return opts.ChunkOverlap
+}
+
+// sentenceSplitter groups the whole sentences into the chunks of up to chunkSize characters;
+// a sentence longer than the chunk size makes a chunk of its own. The chunks repeat the trailing sentences
+// of the previous chunk that fit in chunkOverlap characters
+type sentenceSplitter struct {
+	chunkSize    int
+	chunkOverlap int
+}
+
+// splitSentences splits the text after the sentence ending punctuation followed by a space,
+// leaving the decimals, the abbreviations and the initials within the sentence
+func splitSentences(text string) []string {
+	sentences := []string{}
+	for _, piece := range sentenceRegexp.FindAllString(text, -1) {
+		if n := len(sentences); n > 0 && !endsSentence(sentences[n-1]) {
+			sentences[n-1] += piece
+			continue
+		}
+		sentences = append(sentences, piece)
+	}
+	return sentences
+}
+
+func endsSentence(piece string) bool {
+	last, _ := utf8.DecodeLastRuneInString(piece)
+	if !unicode.IsSpace(last) {
+		return false
+	}
+	words := strings.Fields(piece)
+	if len(words) == 0 {
+		return true
+	}
+	word := strings.TrimLeft(words[len(words)-1], `"'([`)
+	if abbreviations[strings.ToLower(word)] {
+		return false
+	}
+	// an initial, like J. Smith
+	first, _ := utf8.DecodeRuneInString(word)
+	return !(utf8.RuneCountInString(word) == 2 && unicode.IsUpper(first) && strings.HasSuffix(word, "."))
+}
+
+func (s sentenceSplitter) SplitText(text string) ([]string, error) {
+	sentences := splitSentences(text)
+
+	chunks := []string{}
+	current := []string{}
+	currentLen := 0
+	// sentences added since the last chunk, the overlap aside
+	added := 0
+	flush := func() {
+		if chunk := strings.TrimSpace(strings.Join(current, "")); len(chunk) > 0 {
+			chunks = append(chunks, chunk)
+		}
+		// keep the trailing sentences for the overlap
+		overlap := []string{}
+		overlapLen := 0
+		for i := len(current) - 1; i >= 0; i-- {
+			if overlapLen+len(current[i]) > s.chunkOverlap {
+				break
+			}
+			overlap = append([]string{current[i]}, overlap...)
+			overlapLen += len(current[i])
+		}
+		current = overlap
+		currentLen = overlapLen
+		added = 0
+	}
+
+	for _, sentence := range sentences {
+		if currentLen+len(sentence) > s.chunkSize && currentLen > 0 {
+			flush()
+			// the overlap alone must leave room for the sentence
+			if currentLen+len(sentence) > s.chunkSize {
+				current = []string{}
+				currentLen = 0
+			}
+		}
+		current = append(current, sentence)
+		currentLen += len(sentence)
+		added++
+	}
+	if added > 0 {
+		if chunk := strings.TrimSpace(strings.Join(current, "")); len(chunk) > 0 {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks, nil
+}
@@ -0,0 +1,72 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSentenceSplitter(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		chunkSize    int
+		chunkOverlap int
+		chunks       []string
+	}{
+		{
+			name:      "sentences grouped up to the chunk size",
+			text:      "One two. Three four! Five six? Seven.",
+			chunkSize: 21,
+			chunks:    []string{"One two. Three four!", "Five six? Seven."},
+		},
+		{
+			name:      "abbreviations, initials and decimals do not end the sentence",
+			text:      "Warming reached 1.1 degrees, e.g. over land. See Fig. 2 by J. Smith et al. for the details. The end.",
+			chunkSize: 92,
+			chunks:    []string{"Warming reached 1.1 degrees, e.g. over land. See Fig. 2 by J. Smith et al. for the details.", "The end."},
+		},
+		{
+			name:         "overlap repeats the trailing sentences",
+			text:         "Aaaa. Bbbb. Cccc. Dddd.",
+			chunkSize:    12,
+			chunkOverlap: 6,
+			chunks:       []string{"Aaaa. Bbbb.", "Bbbb. Cccc.", "Cccc. Dddd."},
+		},
+		{
+			name:         "overlap leaving no room for the next sentence is dropped",
+			text:         "Aaaa. Bbbbbbbbbbbb.",
+			chunkSize:    14,
+			chunkOverlap: 6,
+			chunks:       []string{"Aaaa.", "Bbbbbbbbbbbb."},
+		},
+		{
+			name:      "sentence longer than the chunk size makes a chunk of its own",
+			text:      "Short. This sentence is longer than the chunk. Tail.",
+			chunkSize: 10,
+			chunks:    []string{"Short.", "This sentence is longer than the chunk.", "Tail."},
+		},
+		{
+			name:      "text with no punctuation",
+			text:      "no punctuation at all",
+			chunkSize: 100,
+			chunks:    []string{"no punctuation at all"},
+		},
+		{
+			name:      "empty text",
+			text:      "",
+			chunkSize: 100,
+			chunks:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := sentenceSplitter{chunkSize: tt.chunkSize, chunkOverlap: tt.chunkOverlap}.SplitText(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := strings.Join(chunks, "|"), strings.Join(tt.chunks, "|"); got != want {
+				t.Errorf("chunks are '%s', want '%s'", got, want)
+			}
+		})
+	}
+}
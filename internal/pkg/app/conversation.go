@@ -0,0 +1,73 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// number of the latest turns used to rewrite follow up questions
+const conversationHistoryLength int = 6
+
+var ErrInvalidConversationID = errors.New("invalid conversation id")
+
+// NewConversationID returns an id for the client to send back with its follow up questions
+func NewConversationID() string {
+	return uuid.New().String()
+}
+
+// ConversationHistory returns the latest turns of the conversation, oldest first; empty for unknown conversations
+func (app *App) ConversationHistory(ctx context.Context, conversationID string) ([]model.ConversationTurn, error) {
+	if _, err := uuid.Parse(conversationID); err != nil {
+		return nil, ErrInvalidConversationID
+	}
+
+	// the expired turns left until the next purge are not used
+	rows, err := app.pgpool.Query(ctx, `SELECT query, improved_prompt, answer, created_at FROM (
+		SELECT * FROM conversation_turn WHERE conversation_id = $1 AND ($3::float8 = 0 OR created_at > now() - make_interval(secs => $3::float8))
+		ORDER BY turn DESC LIMIT $2
+	) AS latest ORDER BY turn`, conversationID, conversationHistoryLength, app.conversationTTL.Seconds())
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	turns := []model.ConversationTurn{}
+	for rows.Next() {
+		turn := model.ConversationTurn{}
+		if err := rows.Scan(&turn.Query, &turn.Prompt, &turn.Answer, &turn.CreatedAt); err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		turns = append(turns, turn)
+	}
+	return turns, rows.Err()
+}
+
+// AddConversationTurn appends the turn to the conversation; the conversation is created with its first turn.
+// The turns older than the conversation ttl are dropped on the way
+func (app *App) AddConversationTurn(ctx context.Context, conversationID string, turn model.ConversationTurn) error {
+	if _, err := uuid.Parse(conversationID); err != nil {
+		return ErrInvalidConversationID
+	}
+
+	if app.conversationTTL > 0 {
+		if _, err := app.pgpool.Exec(ctx, `DELETE FROM conversation_turn WHERE created_at <= now() - make_interval(secs => $1)`,
+			app.conversationTTL.Seconds()); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	_, err := app.pgpool.Exec(ctx, `INSERT INTO conversation_turn (conversation_id, turn, query, improved_prompt, answer)
+	SELECT $1, COALESCE(MAX(turn), 0) + 1, $2, $3, $4 FROM conversation_turn WHERE conversation_id = $1`,
+		conversationID, turn.Query, turn.Prompt, turn.Answer)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"code.sajari.com/docconv"
+	"github.com/tmc/langchaingo/schema"
+)
+
+const (
+	MetadataPageFieldName        string = "page"
+	MetadataPageEndFieldName     string = "page_end"
+	MetadataHeadingFieldName     string = "heading"
+	MetadataOffsetStartFieldName string = "offset_start"
+	MetadataOffsetEndFieldName   string = "offset_end"
+	MetadataSourceURLFieldName   string = "source_url"
+
+	// the longest line still taken for a heading
+	maxHeadingLength int = 100
+	// the fewest letters of an all caps line taken for a heading, so the acronyms like CO2 are not
+	minCapsHeadingLetters int = 4
+)
+
+// DocumentText is the converted file body and the metadata of the file
+type DocumentText struct {
+	Body string
+	// offsets in Body the pages start at, in order; empty when the format has no pages
+	PageOffsets []int
+	// the address the document was fetched from; empty for the uploaded files
+	SourceURL string
+	// the tags given at upload, like the publisher or the year
+	Tags map[string]any
+}
+
+var (
+	// numbered headings like "1.2 Sea level rise" or "A.1 Observed warming", starting with a capital letter
+	numberedHeadingRegexp = regexp.MustCompile(`^([A-Z]\.?)?\d{1,2}(\.\d{1,2})*\.?\s+\p{Lu}`)
+	// headings like "Chapter 3: Oceans"
+	namedHeadingRegexp    = regexp.MustCompile(`^(chapter|section|part|annex|appendix)\s+[\w.]+:?\s+\S`)
+	markdownHeadingRegexp = regexp.MustCompile(`^#{1,6}\s+\S`)
+	dateRegexp            = regexp.MustCompile(`(?i)^\d{1,2}\s+(january|february|march|april|may|june|july|august|september|october|november|december)\s+\d{4}$`)
+)
+
+// the words a line ending the middle of a sentence ends with
+var sentenceContinuations = map[string]bool{
+	"a": true, "an": true, "the": true, "and": true, "or": true, "of": true, "to": true, "in": true, "on": true,
+	"at": true, "by": true, "for": true, "with": true, "from": true, "is": true, "are": true, "was": true, "were": true,
+	"be": true, "that": true, "which": true,
+}
+
+// ConvertDocument converts the file into the plain text; PDF page boundaries are kept
+func ConvertDocument(ctx context.Context, content []byte, contentType string) (DocumentText, error) {
+	if contentType == "application/pdf" {
+		return convertPDF(ctx, content)
+	}
+
+	docConvResponse, err := docconv.Convert(bytes.NewReader(content), contentType, true)
+	if err != nil {
+		return DocumentText{}, err
+	}
+	if len(docConvResponse.Error) > 0 {
+		return DocumentText{}, errors.New(docConvResponse.Error)
+	}
+	// readability drops the whole body of the short pages
+	if contentType == "text/html" && len(strings.TrimSpace(docConvResponse.Body)) == 0 {
+		docConvResponse, err = docconv.Convert(bytes.NewReader(content), contentType, false)
+		if err != nil {
+			return DocumentText{}, err
+		}
+	}
+	return DocumentText{
+		Body: docConvResponse.Body,
+	}, nil
+}
+
+// convertPDF runs pdftotext the same way docconv does, but keeps the page breaks to find the pages offsets
+func convertPDF(ctx context.Context, content []byte) (DocumentText, error) {
+	f, err := docconv.NewLocalFile(bytes.NewReader(content))
+	if err != nil {
+		return DocumentText{}, err
+	}
+	defer f.Done()
+
+	body, err := exec.CommandContext(ctx, "pdftotext", "-q", "-enc", "UTF-8", "-eol", "unix", f.Name(), "-").Output()
+	if err != nil {
+		return DocumentText{}, err
+	}
+	return splitPages(string(body)), nil
+}
+
+// splitPages joins the pages split by the page breaks with the blank lines and records the offsets the pages start at
+func splitPages(body string) DocumentText {
+	text := DocumentText{}
+	builder := strings.Builder{}
+	for i, page := range strings.Split(body, "\f") {
+		// pdftotext ends the last page with the page break too
+		if i > 0 && len(page) == 0 {
+			continue
+		}
+		text.PageOffsets = append(text.PageOffsets, builder.Len())
+		if i > 0 {
+			builder.WriteString("\n\n")
+		}
+		builder.WriteString(page)
+	}
+	text.Body = builder.String()
+	return text
+}
+
+// headings returns the offsets of the lines looking like headings mapped to the heading
+func headings(body string) ([]int, map[int]string) {
+	offsets := []int{}
+	headingsMap := map[int]string{}
+	offset := 0
+	lines := strings.SplitAfter(body, "\n")
+	for i, line := range lines {
+		// the headings are paragraphs of their own, followed by a blank line or the end of the text
+		blankAfter := i+1 == len(lines) || len(strings.TrimSpace(lines[i+1])) == 0
+		if heading := strings.TrimSpace(line); isHeading(heading, blankAfter) {
+			offsets = append(offsets, offset)
+			headingsMap[offset] = strings.TrimSpace(strings.TrimLeft(heading, "#"))
+		}
+		offset += len(line)
+	}
+	return offsets, headingsMap
+}
+
+// isHeading tells the markdown headings, and the numbered, named or all caps lines followed by a blank line
+// which do not stop in the middle of a sentence
+func isHeading(line string, blankAfter bool) bool {
+	if len(line) == 0 || len(line) > maxHeadingLength {
+		return false
+	}
+	if markdownHeadingRegexp.MatchString(line) {
+		return true
+	}
+	if !blankAfter {
+		return false
+	}
+	// headings do not end the sentence, nor stop in its middle
+	if strings.ContainsAny(line[len(line)-1:], ".,;:!?") {
+		return false
+	}
+	words := strings.Fields(line)
+	if sentenceContinuations[strings.ToLower(words[len(words)-1])] {
+		return false
+	}
+	if dateRegexp.MatchString(line) {
+		return false
+	}
+	if numberedHeadingRegexp.MatchString(line) || namedHeadingRegexp.MatchString(strings.ToLower(line)) {
+		return true
+	}
+	// all caps lines like "SUMMARY FOR POLICYMAKERS"
+	letters := 0
+	for _, r := range line {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsLower(r) {
+				return false
+			}
+		}
+	}
+	return letters >= minCapsHeadingLetters
+}
+
+// annotateChunks sets the page and the heading each chunk starts in from the chunk offsets set by loadAndSplit;
+// chunks with no known offset are left as is
+func annotateChunks(docs []schema.Document, text DocumentText) {
+	headingOffsets, headingsMap := headings(text.Body)
+	// returns the index of the last offset at or before the position; -1 when there is none
+	lastAtOrBefore := func(offsets []int, position int) int {
+		return sort.Search(len(offsets), func(i int) bool { return offsets[i] > position }) - 1
+	}
+
+	for i := range docs {
+		start, ok := docs[i].Metadata[MetadataOffsetStartFieldName].(int)
+		if !ok {
+			continue
+		}
+		end, _ := docs[i].Metadata[MetadataOffsetEndFieldName].(int)
+
+		if len(text.PageOffsets) > 0 {
+			docs[i].Metadata[MetadataPageFieldName] = lastAtOrBefore(text.PageOffsets, start) + 1
+			docs[i].Metadata[MetadataPageEndFieldName] = lastAtOrBefore(text.PageOffsets, end-1) + 1
+		}
+		if idx := lastAtOrBefore(headingOffsets, start); idx >= 0 {
+			docs[i].Metadata[MetadataHeadingFieldName] = headingsMap[headingOffsets[idx]]
+		}
+	}
+}
+
+// setChunkOffsets finds the chunks in the text they were split from, in order, and sets their offsets;
+// chunks the splitter altered so they are not found are left without the offsets. maxOverlap is the most characters
+// two neighbour chunks share, -1 when not known
+func setChunkOffsets(docs []schema.Document, text string, maxOverlap int) {
+	searchFrom := 0
+	for i := range docs {
+		idx := strings.Index(text[searchFrom:], docs[i].PageContent)
+		if idx < 0 {
+			continue
+		}
+		start := searchFrom + idx
+		end := start + len(docs[i].PageContent)
+		docs[i].Metadata[MetadataOffsetStartFieldName] = start
+		docs[i].Metadata[MetadataOffsetEndFieldName] = end
+		// the next chunk overlaps the current one by maxOverlap at most
+		searchFrom = start + 1
+		if maxOverlap >= 0 && end-maxOverlap > searchFrom {
+			searchFrom = end - maxOverlap
+		}
+	}
+}
+
+// metadataInt reads the integer stored in the metadata; numbers read back from the json metadata are floats
+func metadataInt(metadata map[string]any, key string) int {
+	switch v := metadata[key].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// metadataTags reads the tags stored in the metadata
+func metadataTags(metadata map[string]any) map[string]any {
+	if v, ok := metadata[MetadataTagsFieldName].(map[string]any); ok && len(v) > 0 {
+		return v
+	}
+	return nil
+}
+
+// metadataString reads the string stored in the metadata
+func metadataString(metadata map[string]any, key string) string {
+	if v, ok := metadata[key].(string); ok {
+		return v
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+func TestIsHeading(t *testing.T) {
+	tests := []struct {
+		line       string
+		blankAfter bool
+		heading    bool
+	}{
+		{line: "1.2 Sea level rise", blankAfter: true, heading: true},
+		{line: "A.1 Observed warming", blankAfter: true, heading: true},
+		{line: "B1 Impacts", blankAfter: true, heading: true},
+		{line: "Chapter 3: Oceans", blankAfter: true, heading: true},
+		{line: "SUMMARY FOR POLICYMAKERS", blankAfter: true, heading: true},
+		{line: "## Mitigation", blankAfter: false, heading: true},
+		{line: "1.2 Sea level rise", blankAfter: false, heading: false},
+		{line: "1.5 degrees of warming is", blankAfter: true, heading: false},
+		{line: "1.5 Degrees of warming is", blankAfter: true, heading: false},
+		{line: "12 June 2021", blankAfter: true, heading: false},
+		{line: "CO2", blankAfter: true, heading: false},
+		{line: "The warming is unequivocal.", blankAfter: true, heading: false},
+		{line: "", blankAfter: true, heading: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if heading := isHeading(tt.line, tt.blankAfter); heading != tt.heading {
+				t.Errorf("heading is '%v', want '%v'", heading, tt.heading)
+			}
+		})
+	}
+}
+
+func TestSplitPages(t *testing.T) {
+	// pdftotext ends every page with the page break
+	text := splitPages("first page\fsecond page\f\fforth page\f")
+
+	if want := "first page\n\nsecond page\n\nforth page"; text.Body != want {
+		t.Errorf("body is '%q', want '%q'", text.Body, want)
+	}
+	// the empty third page is dropped
+	if offsets, want := fmt.Sprint(text.PageOffsets), "[0 10 23]"; offsets != want {
+		t.Errorf("page offsets are '%s', want '%s'", offsets, want)
+	}
+}
+
+// fixtureText is a two page document with the headings starting each page
+const fixtureText = "1 Introduction\n\nThe climate is warming. The oceans are rising.\n\n" +
+	"2 Impacts\n\nThe impacts are widespread. The oceans are rising."
+
+func fixtureChunks(texts ...string) []schema.Document {
+	docs := []schema.Document{}
+	for _, text := range texts {
+		docs = append(docs, schema.Document{PageContent: text, Metadata: map[string]any{}})
+	}
+	return docs
+}
+
+func TestSetChunkOffsets(t *testing.T) {
+	tests := []struct {
+		name       string
+		chunks     []string
+		maxOverlap int
+		offsets    string
+	}{
+		{
+			name:       "repeated text is found after the previous chunk",
+			chunks:     []string{"The climate is warming.", "The oceans are rising.", "The oceans are rising."},
+			maxOverlap: 0,
+			offsets:    "16-39,40-62,103-125",
+		},
+		{
+			name:       "overlapping chunks",
+			chunks:     []string{"The climate is warming. The oceans", "The oceans are rising."},
+			maxOverlap: 10,
+			offsets:    "16-50,40-62",
+		},
+		{
+			name:       "chunk altered by the splitter is skipped",
+			chunks:     []string{"The climate is  warming.", "2 Impacts"},
+			maxOverlap: -1,
+			offsets:    "-,64-73",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs := fixtureChunks(tt.chunks...)
+			setChunkOffsets(docs, fixtureText, tt.maxOverlap)
+
+			offsets := ""
+			for i, doc := range docs {
+				if i > 0 {
+					offsets += ","
+				}
+				if start, ok := doc.Metadata[MetadataOffsetStartFieldName]; ok {
+					offsets += fmt.Sprintf("%v-%v", start, doc.Metadata[MetadataOffsetEndFieldName])
+				} else {
+					offsets += "-"
+				}
+			}
+			if offsets != tt.offsets {
+				t.Errorf("offsets are '%s', want '%s'", offsets, tt.offsets)
+			}
+		})
+	}
+}
+
+func TestAnnotateChunks(t *testing.T) {
+	text := DocumentText{Body: fixtureText, PageOffsets: []int{0, 64}}
+	docs := fixtureChunks("The climate is warming.", "The oceans are rising.\n\n2 Impacts", "The impacts are widespread.", "not found")
+	setChunkOffsets(docs, text.Body, -1)
+	annotateChunks(docs, text)
+
+	annotations := []string{}
+	for _, doc := range docs {
+		annotations = append(annotations, fmt.Sprintf("%v:%v:%v", doc.Metadata[MetadataPageFieldName], doc.Metadata[MetadataPageEndFieldName], doc.Metadata[MetadataHeadingFieldName]))
+	}
+	want := "[1:1:1 Introduction 1:2:1 Introduction 2:2:2 Impacts <nil>:<nil>:<nil>]"
+	if got := fmt.Sprint(annotations); got != want {
+		t.Errorf("annotations are '%s', want '%s'", got, want)
+	}
+}
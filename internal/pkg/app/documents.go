@@ -0,0 +1,225 @@
+package app
+
+import (
+	"context"
+	"errors"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrDocumentNotFound = errors.New("document not found")
+
+// dbtx is satisfied by both the pool and a transaction
+type dbtx interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// lockDocument serializes the transactions changing the same file; the lock is held until the transaction ends
+func lockDocument(ctx context.Context, tx dbtx, fileName string) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, fileName)
+	return err
+}
+
+// ListDocuments returns the indexed files with their chunk counts and summaries
+func (app *App) ListDocuments(ctx context.Context) ([]model.Document, error) {
+	rows, err := app.pgpool.Query(ctx, `SELECT coll.name, coll.cmetadata -> 'chunking', coll.cmetadata -> 'tags', COALESCE((coll.cmetadata ->> 'summary_generated')::boolean, false), COUNT(emb.uuid)
+	FROM langchain_pg_collection AS coll LEFT JOIN langchain_pg_embedding AS emb ON emb.collection_id = coll.uuid
+	WHERE coll.name <> $1
+	GROUP BY coll.uuid
+	ORDER BY coll.name`, DefaultCollectionName)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	documents := []model.Document{}
+	documentsMap := map[string]int{}
+	for rows.Next() {
+		document := model.Document{Summaries: []string{}}
+		if err := rows.Scan(&document.Filename, &document.Chunking, &document.Tags, &document.SummaryGenerated, &document.ChunkCount); err != nil {
+			rows.Close()
+			log.Error(err)
+			return nil, err
+		}
+		documentsMap[document.Filename] = len(documents)
+		documents = append(documents, document)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	summaries, err := app.summaries(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for fileName, fileSummaries := range summaries {
+		if idx, ok := documentsMap[fileName]; ok {
+			documents[idx].Summaries = fileSummaries
+		}
+	}
+
+	return documents, nil
+}
+
+// GetDocument returns the indexed file with its summaries and chunks
+func (app *App) GetDocument(ctx context.Context, fileName string) (model.Document, error) {
+	rows, err := app.pgpool.Query(ctx, `SELECT coll.cmetadata -> 'chunking', coll.cmetadata -> 'tags', COALESCE((coll.cmetadata ->> 'summary_generated')::boolean, false), emb.uuid::text, emb.document, emb.cmetadata
+	FROM langchain_pg_collection AS coll LEFT JOIN langchain_pg_embedding AS emb ON emb.collection_id = coll.uuid
+	WHERE coll.name = $1 AND coll.name <> $2`, fileName, DefaultCollectionName)
+	if err != nil {
+		log.Error(err)
+		return model.Document{}, err
+	}
+	found := false
+	document := model.Document{
+		Filename:  fileName,
+		Summaries: []string{},
+		Chunks:    []model.DocumentChunk{},
+	}
+	for rows.Next() {
+		found = true
+		var id, pageContent *string
+		var metadata map[string]any
+		if err := rows.Scan(&document.Chunking, &document.Tags, &document.SummaryGenerated, &id, &pageContent, &metadata); err != nil {
+			rows.Close()
+			log.Error(err)
+			return model.Document{}, err
+		}
+		// collection without any chunks
+		if id == nil {
+			continue
+		}
+		document.Chunks = append(document.Chunks, model.DocumentChunk{
+			ID:          *id,
+			PageContent: *pageContent,
+			Page:        metadataInt(metadata, MetadataPageFieldName),
+			Heading:     metadataString(metadata, MetadataHeadingFieldName),
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Error(err)
+		return model.Document{}, err
+	}
+	if !found {
+		return model.Document{}, ErrDocumentNotFound
+	}
+	document.ChunkCount = len(document.Chunks)
+
+	summaries, err := app.summaries(ctx, fileName)
+	if err != nil {
+		return model.Document{}, err
+	}
+	if fileSummaries, ok := summaries[fileName]; ok {
+		document.Summaries = fileSummaries
+	}
+	document.RoutingEntries, err = app.documentRoutingEntries(ctx, fileName)
+	if err != nil {
+		return model.Document{}, err
+	}
+
+	return document, nil
+}
+
+// DeleteDocument removes the file collection together with its summary embeddings in the default collection
+func (app *App) DeleteDocument(ctx context.Context, fileName string) error {
+	if fileName == DefaultCollectionName {
+		return ErrDocumentNotFound
+	}
+
+	tx, err := app.pgpool.Begin(ctx)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// wait for the file being indexed, so the deleted file is not indexed back
+	if err := lockDocument(ctx, tx, fileName); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	summariesTag, err := deleteSummaries(ctx, tx, fileName)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	// the file chunks are removed by the cascade on the collection
+	collectionTag, err := tx.Exec(ctx, `DELETE FROM langchain_pg_collection WHERE name = $1`, fileName)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	if summariesTag.RowsAffected() == 0 && collectionTag.RowsAffected() == 0 {
+		return ErrDocumentNotFound
+	}
+	if err := invalidateAnswers(ctx, tx, fileName); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// summaries returns the summaries in the default collection grouped by the file they route to; all the files when fileName is empty
+func (app *App) summaries(ctx context.Context, fileName string) (map[string][]string, error) {
+	rows, err := app.pgpool.Query(ctx, `SELECT emb.cmetadata ->> 'collection_name', emb.document
+	FROM langchain_pg_embedding AS emb JOIN langchain_pg_collection AS coll ON emb.collection_id = coll.uuid
+	WHERE coll.name = $1 AND ($2 = '' OR emb.cmetadata ->> 'collection_name' = $2)
+		AND COALESCE(emb.cmetadata ->> 'routing_kind', $3) = $3`, DefaultCollectionName, fileName, RoutingKindSummary)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := map[string][]string{}
+	for rows.Next() {
+		var collectionName, summary string
+		if err := rows.Scan(&collectionName, &summary); err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		summaries[collectionName] = append(summaries[collectionName], summary)
+	}
+	return summaries, rows.Err()
+}
+
+// documentRoutingEntries returns the entries routing to the file other than the summaries
+func (app *App) documentRoutingEntries(ctx context.Context, fileName string) ([]model.RoutingEntry, error) {
+	rows, err := app.pgpool.Query(ctx, `SELECT emb.cmetadata ->> 'routing_kind', emb.document
+	FROM langchain_pg_embedding AS emb JOIN langchain_pg_collection AS coll ON emb.collection_id = coll.uuid
+	WHERE coll.name = $1 AND emb.cmetadata ->> 'collection_name' = $2 AND emb.cmetadata ->> 'routing_kind' <> $3`,
+		DefaultCollectionName, fileName, RoutingKindSummary)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []model.RoutingEntry{}
+	for rows.Next() {
+		entry := model.RoutingEntry{}
+		if err := rows.Scan(&entry.Kind, &entry.PageContent); err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// deleteSummaries removes the summary and the routing embeddings of the file from the default collection
+func deleteSummaries(ctx context.Context, db dbtx, fileName string) (pgconn.CommandTag, error) {
+	return db.Exec(ctx, `DELETE
+	FROM langchain_pg_embedding AS emb USING langchain_pg_collection AS coll 
+	WHERE emb.collection_id = coll.uuid AND coll.name=$1 AND emb.cmetadata ->> 'collection_name' = $2`, DefaultCollectionName, fileName)
+}
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/jackc/pgx/v5"
+	pgv "github.com/pgvector/pgvector-go"
+)
+
+// the cached embeddings not reused for that many days are dropped
+const embeddingCacheRetentionDays int = 30
+
+// contentHash is the key of the text embedding in the cache
+func contentHash(text string) string {
+	hash := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(hash[:])
+}
+
+// cachedEmbeddings returns the cached vectors of the embedding model by the hashes of the texts found in the cache
+func (app *App) cachedEmbeddings(ctx context.Context, hashes []string) (map[string][]float32, error) {
+	rows, err := app.pgpool.Query(ctx, `UPDATE embedding_cache SET used_at = now()
+	WHERE model = $1 AND content_hash = ANY($2)
+	RETURNING content_hash, embedding`, app.embeddingModel, hashes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vectors := map[string][]float32{}
+	for rows.Next() {
+		var hash string
+		vector := pgv.Vector{}
+		if err := rows.Scan(&hash, &vector); err != nil {
+			return nil, err
+		}
+		vectors[hash] = vector.Slice()
+	}
+	return vectors, rows.Err()
+}
+
+// cacheEmbeddings stores the vectors of the texts by the text hashes
+func (app *App) cacheEmbeddings(ctx context.Context, hashes []string, vectors [][]float32) error {
+	b := &pgx.Batch{}
+	for i, hash := range hashes {
+		b.Queue(`INSERT INTO embedding_cache (model, content_hash, embedding) VALUES ($1, $2, $3)
+		ON CONFLICT (model, content_hash) DO UPDATE SET embedding = EXCLUDED.embedding, used_at = now()`,
+			app.embeddingModel, hash, pgv.NewVector(vectors[i]))
+	}
+	return app.pgpool.SendBatch(ctx, b).Close()
+}
+
+// pruneEmbeddingCache drops the embeddings not reused for the retention period
+func (app *App) pruneEmbeddingCache(ctx context.Context) error {
+	_, err := app.pgpool.Exec(ctx, `DELETE FROM embedding_cache WHERE used_at < now() - make_interval(days => $1)`, embeddingCacheRetentionDays)
+	return err
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// the metadata field keeping the tags given at upload
+	MetadataTagsFieldName string = "tags"
+	// the filter key matching the file names instead of a tag
+	FilterKeyFilename string = "filename"
+
+	FilterOperatorEqual          string = ":"
+	FilterOperatorNotEqual       string = "!="
+	FilterOperatorGreater        string = ">"
+	FilterOperatorGreaterOrEqual string = ">="
+	FilterOperatorLess           string = "<"
+	FilterOperatorLessOrEqual    string = "<="
+
+	// separates the values of the equality filters, any of the values matches
+	filterValuesSeparator string = "|"
+)
+
+var (
+	ErrInvalidFilter = errors.New("invalid filter")
+	ErrInvalidTags   = errors.New("invalid tags")
+
+	tagKeyRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+	// the two character operators go first, so ">=" is not read as ">"
+	filterRegexp  = regexp.MustCompile(`^\s*([a-z_][a-z0-9_]*)\s*(:|!=|>=|<=|>|<)\s*(.+?)\s*$`)
+	numericRegexp = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+)
+
+// MetadataFilter restricts the search to the chunks whose tag compares to the values; the filename key matches the file names
+type MetadataFilter struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// ParseMetadataFilter reads the filter expression like `publisher:IPCC`, `year>=2020`, `region!=europe` or `filename:a.pdf|b.pdf`,
+// where the equality and the inequality take any of the values separated by `|`
+func ParseMetadataFilter(expression string) (MetadataFilter, error) {
+	match := filterRegexp.FindStringSubmatch(expression)
+	if match == nil {
+		return MetadataFilter{}, fmt.Errorf("%w: '%s'", ErrInvalidFilter, expression)
+	}
+
+	filter := MetadataFilter{Key: match[1], Operator: match[2]}
+	switch filter.Operator {
+	case FilterOperatorEqual, FilterOperatorNotEqual:
+		for _, value := range strings.Split(match[3], filterValuesSeparator) {
+			if value = strings.TrimSpace(value); len(value) > 0 {
+				filter.Values = append(filter.Values, value)
+			}
+		}
+		if len(filter.Values) == 0 {
+			return MetadataFilter{}, fmt.Errorf("%w: '%s'", ErrInvalidFilter, expression)
+		}
+	default:
+		// the comparisons are numeric only, the file names are not compared
+		if !numericRegexp.MatchString(match[3]) || filter.Key == FilterKeyFilename {
+			return MetadataFilter{}, fmt.Errorf("%w: '%s', the comparisons take a number", ErrInvalidFilter, expression)
+		}
+		filter.Values = []string{match[3]}
+	}
+	return filter, nil
+}
+
+// ValidateTags checks the tag keys are the lowercase identifiers the filters can refer to and the values are scalars
+func ValidateTags(tags map[string]any) error {
+	for key, value := range tags {
+		if !tagKeyRegexp.MatchString(key) || key == FilterKeyFilename {
+			return fmt.Errorf("%w: invalid key '%s'", ErrInvalidTags, key)
+		}
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			return fmt.Errorf("%w: the value of '%s' must be a string, a number or a boolean", ErrInvalidTags, key)
+		}
+	}
+	return nil
+}
+
+// filtersSQL turns the filters into the conditions on the cmetadata of the langchain_pg_embedding aliased emb, to be AND-ed
+// to the query; the keys and the values are passed as the query arguments appended to args
+func filtersSQL(filters []MetadataFilter, args []any) (string, []any) {
+	conditions := []string{}
+	for _, filter := range filters {
+		field := fmt.Sprintf("(emb.cmetadata -> '%s' ->> $%d)", MetadataTagsFieldName, len(args)+1)
+		if filter.Key == FilterKeyFilename {
+			field = fmt.Sprintf("(emb.cmetadata ->> '%s')", MetadataCollectionFieldName)
+		} else {
+			args = append(args, filter.Key)
+		}
+
+		switch filter.Operator {
+		case FilterOperatorEqual:
+			args = append(args, filter.Values)
+			conditions = append(conditions, fmt.Sprintf("%s = ANY($%d)", field, len(args)))
+		case FilterOperatorNotEqual:
+			// the chunks with no such tag are kept
+			args = append(args, filter.Values)
+			conditions = append(conditions, fmt.Sprintf("COALESCE(%s <> ALL($%d), true)", field, len(args)))
+		default:
+			// compare as numbers; the values which are not numbers never match
+			number, _ := strconv.ParseFloat(filter.Values[0], 64)
+			args = append(args, number)
+			conditions = append(conditions, fmt.Sprintf("(CASE WHEN %s ~ '%s' THEN %s::numeric END) %s $%d::numeric",
+				field, numericRegexp.String(), field, filter.Operator, len(args)))
+		}
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " AND " + strings.Join(conditions, " AND "), args
+}
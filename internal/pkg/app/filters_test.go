@@ -0,0 +1,103 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestParseMetadataFilter(t *testing.T) {
+	tests := []struct {
+		expression string
+		filter     string
+		err        error
+	}{
+		{expression: "publisher:IPCC", filter: "publisher : [IPCC]"},
+		{expression: " region != europe | global ", filter: "region != [europe global]"},
+		{expression: "year>=2020", filter: "year >= [2020]"},
+		{expression: "warming<1.5", filter: "warming < [1.5]"},
+		{expression: "delta>-0.5", filter: "delta > [-0.5]"},
+		{expression: "filename:a.pdf|b.pdf", filter: "filename : [a.pdf b.pdf]"},
+		{expression: "year>=recent", err: ErrInvalidFilter},
+		{expression: "year<2020a", err: ErrInvalidFilter},
+		{expression: "filename>a.pdf", err: ErrInvalidFilter},
+		{expression: "region:|", err: ErrInvalidFilter},
+		{expression: "Region:europe", err: ErrInvalidFilter},
+		{expression: "tags->>'x':y", err: ErrInvalidFilter},
+		{expression: "region", err: ErrInvalidFilter},
+		{expression: "", err: ErrInvalidFilter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			filter, err := ParseMetadataFilter(tt.expression)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error is '%v', want '%v'", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if got := fmt.Sprintf("%s %s %v", filter.Key, filter.Operator, filter.Values); got != tt.filter {
+				t.Errorf("filter is '%s', want '%s'", got, tt.filter)
+			}
+		})
+	}
+}
+
+func TestFiltersSQL(t *testing.T) {
+	tests := []struct {
+		name        string
+		expressions []string
+		sql         string
+		args        string
+	}{
+		{
+			name: "no filters",
+			sql:  "",
+			args: "[query]",
+		},
+		{
+			name:        "the key is passed as an argument",
+			expressions: []string{"publisher:IPCC|NASA"},
+			sql:         " AND (emb.cmetadata -> 'tags' ->> $2) = ANY($3)",
+			args:        "[query publisher [IPCC NASA]]",
+		},
+		{
+			name:        "inequality keeps the chunks without the tag",
+			expressions: []string{"region!=europe"},
+			sql:         " AND COALESCE((emb.cmetadata -> 'tags' ->> $2) <> ALL($3), true)",
+			args:        "[query region [europe]]",
+		},
+		{
+			name:        "numeric comparison",
+			expressions: []string{"year>=2020"},
+			sql:         ` AND (CASE WHEN (emb.cmetadata -> 'tags' ->> $2) ~ '^-?\d+(\.\d+)?$' THEN (emb.cmetadata -> 'tags' ->> $2)::numeric END) >= $3::numeric`,
+			args:        "[query year 2020]",
+		},
+		{
+			name:        "filename and tag filters",
+			expressions: []string{"filename:a.pdf", "year<2022"},
+			sql: ` AND (emb.cmetadata ->> 'collection_name') = ANY($2)` +
+				` AND (CASE WHEN (emb.cmetadata -> 'tags' ->> $3) ~ '^-?\d+(\.\d+)?$' THEN (emb.cmetadata -> 'tags' ->> $3)::numeric END) < $4::numeric`,
+			args: "[query [a.pdf] year 2022]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters := []MetadataFilter{}
+			for _, expression := range tt.expressions {
+				filter, err := ParseMetadataFilter(expression)
+				if err != nil {
+					t.Fatal(err)
+				}
+				filters = append(filters, filter)
+			}
+			sql, args := filtersSQL(filters, []any{"query"})
+			if sql != tt.sql {
+				t.Errorf("sql is '%s', want '%s'", sql, tt.sql)
+			}
+			if got := fmt.Sprint(args); got != tt.args {
+				t.Errorf("args are '%s', want '%s'", got, tt.args)
+			}
+		})
+	}
+}
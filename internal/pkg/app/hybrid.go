@@ -0,0 +1,56 @@
+package app
+
+import (
+	"context"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// the reciprocal rank fusion constant; dampens the weight of the top ranks so neither ranking dominates
+const rrfK int = 60
+
+// keywordSearch runs the full-text search over the collection chunks matching the filters; any of the query terms matches
+// and the chunks are ranked by the terms coverage
+func (app *App) keywordSearch(ctx context.Context, collectionName string, query string, numDocuments int, metadataFilters []MetadataFilter) ([]schema.Document, error) {
+	filters, args := filtersSQL(metadataFilters, []any{collectionName, query, numDocuments})
+	rows, err := app.pgpool.Query(ctx, `SELECT emb.document, emb.cmetadata, ts_rank_cd(to_tsvector('english', emb.document), tsq.query) AS rank
+	FROM langchain_pg_embedding AS emb
+		JOIN langchain_pg_collection AS coll ON emb.collection_id = coll.uuid,
+		(SELECT NULLIF(replace(plainto_tsquery('english', $2)::text, '&', '|'), '')::tsquery AS query) AS tsq
+	WHERE coll.name = $1 AND to_tsvector('english', emb.document) @@ tsq.query`+filters+`
+	ORDER BY rank DESC
+	LIMIT $3`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	docs := []schema.Document{}
+	for rows.Next() {
+		doc := schema.Document{}
+		if err := rows.Scan(&doc.PageContent, &doc.Metadata, &doc.Score); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// fuseRankings merges the rankings with the reciprocal rank fusion; the returned docs carry the fused score, higher is better
+func fuseRankings(rankings ...[]schema.Document) []schema.Document {
+	fused := []schema.Document{}
+	fusedIdx := map[string]int{}
+	for _, ranking := range rankings {
+		for rank, doc := range ranking {
+			score := float32(1) / float32(rrfK+rank+1)
+			if idx, ok := fusedIdx[doc.PageContent]; ok {
+				fused[idx].Score += score
+				continue
+			}
+			doc.Score = score
+			fusedIdx[doc.PageContent] = len(fused)
+			fused = append(fused, doc)
+		}
+	}
+	return fused
+}
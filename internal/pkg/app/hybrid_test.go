@@ -0,0 +1,53 @@
+package app
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+func TestFuseRankings(t *testing.T) {
+	ranking := func(texts ...string) []schema.Document {
+		docs := []schema.Document{}
+		for _, text := range texts {
+			// the scores of the rankings are not comparable and are replaced by the fused one
+			docs = append(docs, schema.Document{PageContent: text, Score: 0.5})
+		}
+		return docs
+	}
+	rrf := func(ranks ...int) float32 {
+		score := float32(0)
+		for _, rank := range ranks {
+			score += float32(1) / float32(rrfK+rank)
+		}
+		return score
+	}
+
+	semantic := ranking("a", "b", "c")
+	keyword := ranking("c", "d", "a")
+	fused := fuseRankings(semantic, keyword)
+
+	// the docs found by both are kept once, in the order they are first found
+	if names := docNames(fused); names != "a,b,c,d" {
+		t.Fatalf("fused docs are %s, want a,b,c,d", names)
+	}
+	scores := map[string]float32{
+		"a": rrf(1, 3),
+		"b": rrf(2),
+		"c": rrf(3, 1),
+		"d": rrf(2),
+	}
+	for _, doc := range fused {
+		if math.Abs(float64(doc.Score-scores[doc.PageContent])) > 1e-7 {
+			t.Errorf("score of %s is '%v', want '%v'", doc.PageContent, doc.Score, scores[doc.PageContent])
+		}
+	}
+	// found by both rankings beats found by one, whatever the rank
+	if fused[2].Score <= fused[1].Score {
+		t.Errorf("score of c '%v' is not above the score of b '%v'", fused[2].Score, fused[1].Score)
+	}
+	if semantic[0].Score != 0.5 {
+		t.Error("the ranking docs are changed")
+	}
+}
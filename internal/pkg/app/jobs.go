@@ -0,0 +1,248 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+const jobPollInterval = 5 * time.Second
+
+var ErrJobNotFound = errors.New("job not found")
+
+// MaxUploadSize returns the largest file in bytes the upload accepts
+func (app *App) MaxUploadSize() int64 {
+	return app.maxUploadSize
+}
+
+// EnqueueIngestion persists the uploaded file as a queued ingestion job; the job is picked up by the ingestion workers
+func (app *App) EnqueueIngestion(ctx context.Context, fileName, contentType, summary string, content []byte, chunking model.ChunkingOptions, tags map[string]any) (model.Job, error) {
+	return app.enqueueJob(ctx, fileName, contentType, summary, content, "", chunking, tags)
+}
+
+// enqueueJob queues either the uploaded content or the url to fetch the content from
+func (app *App) enqueueJob(ctx context.Context, fileName, contentType, summary string, content []byte, sourceURL string, chunking model.ChunkingOptions, tags map[string]any) (model.Job, error) {
+	if err := validateChunkingOptions(chunking); err != nil {
+		return model.Job{}, err
+	}
+	if err := ValidateTags(tags); err != nil {
+		return model.Job{}, err
+	}
+
+	job := model.Job{}
+	err := app.pgpool.QueryRow(ctx, `INSERT INTO ingestion_job (id, filename, content_type, summary, content, source_url, chunking, tags, status)
+	VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9)
+	RETURNING id::text, filename, COALESCE(source_url, ''), status, chunks_total, chunks_indexed, created_at, updated_at`,
+		uuid.New().String(), fileName, contentType, summary, content, sourceURL, chunking, tags, model.JobStatusQueued,
+	).Scan(&job.ID, &job.Filename, &job.SourceURL, &job.Status, &job.ChunksTotal, &job.ChunksIndexed, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		log.Error(err)
+		return model.Job{}, err
+	}
+
+	// wake up an idle worker; the workers poll the queue anyway
+	select {
+	case app.jobsNotify <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+// GetJob returns the ingestion job status and progress
+func (app *App) GetJob(ctx context.Context, id string) (model.Job, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return model.Job{}, ErrJobNotFound
+	}
+
+	job := model.Job{}
+	var jobError *string
+	err := app.pgpool.QueryRow(ctx, `SELECT id::text, filename, COALESCE(source_url, ''), status, chunks_total, chunks_indexed, chunks_cached,
+		chunks_added, chunks_removed, chunks_unchanged, error, created_at, updated_at
+	FROM ingestion_job WHERE id = $1`, id,
+	).Scan(&job.ID, &job.Filename, &job.SourceURL, &job.Status, &job.ChunksTotal, &job.ChunksIndexed, &job.ChunksCached,
+		&job.ChunksAdded, &job.ChunksRemoved, &job.ChunksUnchanged, &jobError, &job.CreatedAt, &job.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.Job{}, ErrJobNotFound
+	}
+	if err != nil {
+		log.Error(err)
+		return model.Job{}, err
+	}
+	if jobError != nil {
+		job.Error = *jobError
+	}
+	if job.ChunksIndexed > 0 {
+		job.EmbeddingCacheHitRate = float64(job.ChunksCached) / float64(job.ChunksIndexed)
+	}
+
+	return job, nil
+}
+
+// startIngestionWorkers runs the worker pool until the context is done.
+// Jobs left in progress by a previous run are queued again
+func (app *App) startIngestionWorkers(ctx context.Context, numWorkers int) error {
+	_, err := app.pgpool.Exec(ctx, `UPDATE ingestion_job SET status = $1, updated_at = now() WHERE status IN ($2, $3, $4)`,
+		model.JobStatusQueued, model.JobStatusConverting, model.JobStatusSummarizing, model.JobStatusEmbedding)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < numWorkers; i++ {
+		go app.ingestionWorker(ctx)
+	}
+	return nil
+}
+
+func (app *App) ingestionWorker(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		// drain the queue before going idle
+		for {
+			processed, err := app.processNextJob(ctx)
+			if err != nil {
+				log.Error(err)
+			}
+			if !processed {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-app.jobsNotify:
+		}
+	}
+}
+
+// processNextJob claims the oldest queued job and runs it; returns false when the queue is empty
+func (app *App) processNextJob(ctx context.Context) (bool, error) {
+	var (
+		id, fileName, contentType, summary, sourceURL string
+		content                                       []byte
+		chunking                                      *model.ChunkingOptions
+		tags                                          map[string]any
+	)
+	err := app.pgpool.QueryRow(ctx, `UPDATE ingestion_job SET status = $1, updated_at = now()
+	WHERE id = (
+		SELECT id FROM ingestion_job WHERE status = $2 ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id::text, filename, content_type, summary, content, COALESCE(source_url, ''), chunking, tags`, model.JobStatusConverting, model.JobStatusQueued,
+	).Scan(&id, &fileName, &contentType, &summary, &content, &sourceURL, &chunking, &tags)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// jobs queued before the chunking was configurable are split the default way
+	if chunking == nil {
+		chunking = &app.chunking
+	}
+
+	logger := log.WithField("job", id)
+	logger.Infof("processing ingestion of '%s'", fileName)
+	if err := app.runIngestion(ctx, id, fileName, contentType, summary, content, sourceURL, *chunking, tags); err != nil {
+		logger.Error(err)
+		_, updateErr := app.pgpool.Exec(ctx, `UPDATE ingestion_job SET status = $1, error = $2, content = NULL, updated_at = now() WHERE id = $3`,
+			model.JobStatusFailed, err.Error(), id)
+		return true, updateErr
+	}
+
+	_, err = app.pgpool.Exec(ctx, `UPDATE ingestion_job SET status = $1, content = NULL, updated_at = now() WHERE id = $2`,
+		model.JobStatusDone, id)
+	logger.Infof("ingestion of '%s' is done", fileName)
+	return true, err
+}
+
+func (app *App) runIngestion(ctx context.Context, id, fileName, contentType, summary string, content []byte, sourceURL string, chunking model.ChunkingOptions, tags map[string]any) error {
+	// the url jobs are fetched by the worker, so queueing a whole sitemap stays quick
+	if len(sourceURL) > 0 {
+		var err error
+		content, contentType, err = fetchURL(ctx, sourceURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	text, err := ConvertDocument(ctx, content, contentType)
+	if err != nil {
+		return fmt.Errorf("failed to process file: %w", err)
+	}
+	text.SourceURL = sourceURL
+	text.Tags = tags
+
+	// the summary drives the routing of the search, so one is generated when none was given;
+	// the summaries of the file parts are the routing entries of the file as well
+	summaryGenerated := false
+	routing := []model.RoutingEntry{}
+	if len(summary) == 0 || app.routingEntries {
+		if err := app.setJobStatus(ctx, id, model.JobStatusSummarizing); err != nil {
+			return err
+		}
+		partSummaries, err := app.summarizeParts(ctx, text.Body)
+		if err != nil {
+			return fmt.Errorf("failed to summarize the document: %w", err)
+		}
+		if len(summary) == 0 {
+			summary, err = app.summaryFromParts(ctx, partSummaries)
+			if err != nil {
+				return fmt.Errorf("failed to generate the summary: %w", err)
+			}
+			summaryGenerated = true
+			if _, err := app.pgpool.Exec(ctx, `UPDATE ingestion_job SET summary = $1, updated_at = now() WHERE id = $2`, summary, id); err != nil {
+				return err
+			}
+		}
+		if app.routingEntries {
+			routing, err = app.generateRoutingEntries(ctx, partSummaries)
+			if err != nil {
+				return fmt.Errorf("failed to generate the routing entries: %w", err)
+			}
+		}
+	}
+
+	err = app.setJobStatus(ctx, id, model.JobStatusEmbedding)
+	if err != nil {
+		return err
+	}
+
+	// index the summary into the base collection and the docs in the collection named same as file
+	req := IndexRequest{
+		FileName:         fileName,
+		Summary:          summary,
+		SummaryGenerated: summaryGenerated,
+		Routing:          routing,
+		Text:             text,
+		Chunking:         chunking,
+	}
+	stats, err := app.IndexDocument(ctx, req, func(indexed, cached, total int) {
+		_, err := app.pgpool.Exec(ctx, `UPDATE ingestion_job SET chunks_indexed = $1, chunks_cached = $2, chunks_total = $3, updated_at = now() WHERE id = $4`,
+			indexed, cached, total, id)
+		if err != nil {
+			log.WithField("job", id).Error(err)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to index the document: %w", err)
+	}
+
+	_, err = app.pgpool.Exec(ctx, `UPDATE ingestion_job SET chunks_added = $1, chunks_removed = $2, chunks_unchanged = $3, updated_at = now() WHERE id = $4`,
+		stats.Added, stats.Removed, stats.Unchanged, id)
+	return err
+}
+
+func (app *App) setJobStatus(ctx context.Context, id string, status model.JobStatus) error {
+	_, err := app.pgpool.Exec(ctx, `UPDATE ingestion_job SET status = $1, updated_at = now() WHERE id = $2`, status, id)
+	return err
+}
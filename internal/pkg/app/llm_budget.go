@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// the rough number of characters per token, for the providers not reporting the token usage
+const charactersPerToken int = 4
+
+type llmClientContextKey struct{}
+
+// WithLLMClient marks the llm calls made with the context as the calls of the client, so they are charged to the client daily budget;
+// the calls made with no client, like the ones of the ingestion, are not charged
+func WithLLMClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, llmClientContextKey{}, client)
+}
+
+// LLMUsage is the number of the llm calls and the tokens used by a client since the start of the day (UTC)
+type LLMUsage struct {
+	Calls  int
+	Tokens int
+}
+
+// llmBudget keeps the daily llm usage of the clients in memory
+type llmBudget struct {
+	mu        sync.Mutex
+	maxCalls  int
+	maxTokens int
+	day       time.Time
+	usage     map[string]*LLMUsage
+}
+
+func newLLMBudget(maxCalls, maxTokens int) *llmBudget {
+	return &llmBudget{
+		maxCalls:  maxCalls,
+		maxTokens: maxTokens,
+		usage:     map[string]*LLMUsage{},
+	}
+}
+
+// resetOnNewDay drops the usage of the past day; must be called with the lock held
+func (b *llmBudget) resetOnNewDay(now time.Time) {
+	if day := now.UTC().Truncate(24 * time.Hour); !day.Equal(b.day) {
+		b.day = day
+		b.usage = map[string]*LLMUsage{}
+	}
+}
+
+func (b *llmBudget) charge(client string, tokens int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.resetOnNewDay(time.Now())
+	usage, ok := b.usage[client]
+	if !ok {
+		usage = &LLMUsage{}
+		b.usage[client] = usage
+	}
+	usage.Calls++
+	usage.Tokens += tokens
+}
+
+// exceeded returns the time left till the budget is reset when the client has used it up
+func (b *llmBudget) exceeded(client string) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.resetOnNewDay(now)
+	usage, ok := b.usage[client]
+	if !ok {
+		return 0, false
+	}
+	if (b.maxCalls > 0 && usage.Calls >= b.maxCalls) || (b.maxTokens > 0 && usage.Tokens >= b.maxTokens) {
+		return b.day.Add(24 * time.Hour).Sub(now), true
+	}
+	return 0, false
+}
+
+// LLMBudgetExceeded tells whether the client has used up the daily llm budget and how long till it is reset
+func (app *App) LLMBudgetExceeded(client string) (time.Duration, bool) {
+	return app.llmBudget.exceeded(client)
+}
+
+// meteredLLM charges the calls made on behalf of the clients to their daily budget. The call using the budget up is completed,
+// the budget is enforced before the client requests
+type meteredLLM struct {
+	llms.Model
+	budget *llmBudget
+}
+
+func (m *meteredLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	resp, err := m.Model.GenerateContent(ctx, messages, options...)
+	client, ok := ctx.Value(llmClientContextKey{}).(string)
+	if !ok {
+		return resp, err
+	}
+	// the failed calls are charged too, as the provider may count them
+	m.budget.charge(client, usedTokens(messages, resp))
+	return resp, err
+}
+
+func (m *meteredLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, m, prompt, options...)
+}
+
+// usedTokens reads the token usage the provider reports and estimates it from the text length otherwise
+func usedTokens(messages []llms.MessageContent, resp *llms.ContentResponse) int {
+	characters := 0
+	for _, message := range messages {
+		for _, part := range message.Parts {
+			if textPart, ok := part.(llms.TextContent); ok {
+				characters += len(textPart.Text)
+			}
+		}
+	}
+	if resp != nil {
+		for _, choice := range resp.Choices {
+			if tokens, ok := choice.GenerationInfo["TotalTokens"].(int); ok {
+				return tokens
+			}
+			characters += len(choice.Content)
+		}
+	}
+	return characters / charactersPerToken
+}
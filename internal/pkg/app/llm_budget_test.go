@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestLLMBudgetExceeded(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxCalls  int
+		maxTokens int
+		calls     int
+		tokens    int
+		exceeded  bool
+	}{
+		{name: "under the calls", maxCalls: 3, calls: 2, tokens: 100, exceeded: false},
+		{name: "calls used up", maxCalls: 3, calls: 3, tokens: 100, exceeded: true},
+		{name: "under the tokens", maxTokens: 1000, calls: 5, tokens: 999, exceeded: false},
+		{name: "tokens used up", maxTokens: 1000, calls: 1, tokens: 1000, exceeded: true},
+		{name: "no limits", calls: 100, tokens: 100000, exceeded: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := newLLMBudget(tt.maxCalls, tt.maxTokens)
+			for i := 0; i < tt.calls; i++ {
+				tokens := 0
+				if i == 0 {
+					tokens = tt.tokens
+				}
+				budget.charge("client", tokens)
+			}
+			retryAfter, exceeded := budget.exceeded("client")
+			if exceeded != tt.exceeded {
+				t.Fatalf("exceeded is '%v', want '%v'", exceeded, tt.exceeded)
+			}
+			if exceeded && (retryAfter <= 0 || retryAfter > 24*time.Hour) {
+				t.Errorf("retry after is '%v', want till the end of the day", retryAfter)
+			}
+			if _, exceeded := budget.exceeded("other client"); exceeded {
+				t.Error("budget of another client is exceeded")
+			}
+		})
+	}
+}
+
+func TestLLMBudgetDailyReset(t *testing.T) {
+	budget := newLLMBudget(1, 0)
+	budget.charge("client", 10)
+	if _, exceeded := budget.exceeded("client"); !exceeded {
+		t.Fatal("budget is not exceeded")
+	}
+
+	// the usage was charged the day before
+	budget.mu.Lock()
+	budget.day = budget.day.Add(-24 * time.Hour)
+	budget.mu.Unlock()
+	if _, exceeded := budget.exceeded("client"); exceeded {
+		t.Error("budget is not reset on the new day")
+	}
+}
+
+func TestMeteredLLM(t *testing.T) {
+	budget := newLLMBudget(2, 0)
+	llm := &meteredLLM{Model: NewFakeLLM(), budget: budget}
+
+	// the ingestion calls are not charged
+	if _, err := llm.Call(context.Background(), "summarize the report"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithLLMClient(context.Background(), "client")
+	for i := 0; i < 2; i++ {
+		if _, err := llms.GenerateFromSinglePrompt(ctx, llm, "how fast are the oceans warming"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	usage := budget.usage["client"]
+	// the fake reports no usage, so the tokens are estimated from the prompt and the echoed answer
+	if usage == nil || usage.Calls != 2 || usage.Tokens != 2*(2*len("how fast are the oceans warming")/charactersPerToken) {
+		t.Errorf("usage is '%+v', want 2 calls", usage)
+	}
+	if len(budget.usage) != 1 {
+		t.Errorf("usage is charged to '%d' clients, want '1'", len(budget.usage))
+	}
+	if _, exceeded := budget.exceeded("client"); !exceeded {
+		t.Error("budget is not exceeded")
+	}
+}
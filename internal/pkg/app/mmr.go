@@ -0,0 +1,58 @@
+package app
+
+import (
+	"math"
+
+	"github.com/tmc/langchaingo/schema"
+)
+
+// with the mmr strategy, the search looks into <mmrCandidatesFactor> times more pages in each collection to select from
+const mmrCandidatesFactor int = 4
+
+// maximalMarginalRelevance selects up to n docs one by one, each time taking the doc maximizing
+// lambda * similarity to the query - (1 - lambda) * the highest similarity to the docs selected already.
+// The docs carry the cosine distance to the query as the score and the vectors are their embeddings; the selected docs keep their score
+func maximalMarginalRelevance(docs []schema.Document, vectors [][]float32, n int, lambda float32) []schema.Document {
+	selected := []schema.Document{}
+	selectedVectors := [][]float32{}
+	used := make([]bool, len(docs))
+	for len(selected) < n && len(selected) < len(docs) {
+		best := -1
+		bestScore := math.Inf(-1)
+		for i, doc := range docs {
+			if used[i] {
+				continue
+			}
+			redundancy := 0.0
+			for j, selectedVector := range selectedVectors {
+				if similarity := cosineSimilarity(vectors[i], selectedVector); j == 0 || similarity > redundancy {
+					redundancy = similarity
+				}
+			}
+			score := float64(lambda)*(1-float64(doc.Score)) - float64(1-lambda)*redundancy
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		used[best] = true
+		selected = append(selected, docs[best])
+		selectedVectors = append(selectedVectors, vectors[best])
+	}
+	return selected
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		if i >= len(b) {
+			break
+		}
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
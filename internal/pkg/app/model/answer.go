@@ -0,0 +1,9 @@
+package model
+
+// Answer is the answer to the query together with the pages it is based on
+type Answer struct {
+	Answer    string               `json:"answer"`
+	Prompt    string               `json:"improved_prompt,omitempty"`
+	Sources   []SearchResultsEntry `json:"sources,omitempty"`
+	Citations []Citation           `json:"citations,omitempty"`
+}
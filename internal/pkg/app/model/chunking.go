@@ -0,0 +1,8 @@
+package model
+
+type ChunkingOptions struct {
+	Strategy     string `json:"strategy"`
+	ChunkSize    int    `json:"chunk_size"`
+	ChunkOverlap int    `json:"chunk_overlap"`
+	MinChunkSize int    `json:"min_chunk_size"`
+}
@@ -0,0 +1,11 @@
+package model
+
+// Citation maps the [Index] marker in the answer to the search results entry supporting the claim
+type Citation struct {
+	Index       int    `json:"index"`
+	Filename    string `json:"filename"`
+	Page        int    `json:"page,omitempty"`
+	Heading     string `json:"heading,omitempty"`
+	PageContent string `json:"content"`
+	SourceURL   string `json:"source_url,omitempty"`
+}
@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+type ConversationTurn struct {
+	Query     string    `json:"query"`
+	Prompt    string    `json:"improved_prompt"`
+	Answer    string    `json:"answer"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package model
+
+type Document struct {
+	Filename         string           `json:"filename"`
+	ChunkCount       int              `json:"chunk_count"`
+	Summaries        []string         `json:"summaries"`
+	SummaryGenerated bool             `json:"summary_generated"`
+	Chunking         *ChunkingOptions `json:"chunking,omitempty"`
+	Tags             map[string]any   `json:"tags,omitempty"`
+	RoutingEntries   []RoutingEntry   `json:"routing_entries,omitempty"`
+	Chunks           []DocumentChunk  `json:"chunks,omitempty"`
+}
+
+type DocumentChunk struct {
+	ID          string `json:"id"`
+	PageContent string `json:"content"`
+	Page        int    `json:"page,omitempty"`
+	Heading     string `json:"heading,omitempty"`
+}
@@ -0,0 +1,8 @@
+package model
+
+// IndexStats are the numbers of the file chunks inserted, deleted and kept by the indexing
+type IndexStats struct {
+	Added     int `json:"added"`
+	Removed   int `json:"removed"`
+	Unchanged int `json:"unchanged"`
+}
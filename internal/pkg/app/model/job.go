@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+type JobStatus string
+
+const (
+	JobStatusQueued      JobStatus = "queued"
+	JobStatusConverting  JobStatus = "converting"
+	JobStatusSummarizing JobStatus = "summarizing"
+	JobStatusEmbedding   JobStatus = "embedding"
+	JobStatusDone        JobStatus = "done"
+	JobStatusFailed      JobStatus = "failed"
+)
+
+type Job struct {
+	ID            string    `json:"id"`
+	Filename      string    `json:"filename"`
+	SourceURL     string    `json:"source_url,omitempty"`
+	Status        JobStatus `json:"status"`
+	ChunksTotal   int       `json:"chunks_total"`
+	ChunksIndexed int       `json:"chunks_indexed"`
+	// the indexed chunks not sent to the embedder: the ones kept from the previous version of the file and the ones found in the embedding cache
+	ChunksCached          int     `json:"chunks_cached"`
+	EmbeddingCacheHitRate float64 `json:"embedding_cache_hit_rate"`
+	// the chunks inserted, deleted and kept updating the indexed file; all the chunks are added for a new file
+	ChunksAdded     int       `json:"chunks_added"`
+	ChunksRemoved   int       `json:"chunks_removed"`
+	ChunksUnchanged int       `json:"chunks_unchanged"`
+	Error           string    `json:"error,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
@@ -0,0 +1,7 @@
+package model
+
+// RoutingEntry is indexed in the default collection next to the file summary to route the queries to the file
+type RoutingEntry struct {
+	Kind        string `json:"kind"`
+	PageContent string `json:"content"`
+}
@@ -5,7 +5,15 @@ type SearchResults struct {
 }
 
 type SearchResultsEntry struct {
-	PageContent string  `json:"content"`
-	Score       float32 `json:"score"`
-	Filename    string  `json:"filename"`
+	PageContent string         `json:"content"`
+	Score       float32        `json:"score"`
+	Relevance   float32        `json:"relevance,omitempty"`
+	Filename    string         `json:"filename"`
+	Page        int            `json:"page,omitempty"`
+	PageEnd     int            `json:"page_end,omitempty"`
+	Heading     string         `json:"heading,omitempty"`
+	OffsetStart int            `json:"offset_start"`
+	OffsetEnd   int            `json:"offset_end"`
+	SourceURL   string         `json:"source_url,omitempty"`
+	Tags        map[string]any `json:"tags,omitempty"`
 }
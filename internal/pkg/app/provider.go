@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/config"
+	"github.com/tmc/langchaingo/embeddings"
+	"github.com/tmc/langchaingo/llms"
+	"github.com/tmc/langchaingo/llms/googleai"
+	"github.com/tmc/langchaingo/llms/ollama"
+	"github.com/tmc/langchaingo/llms/openai"
+)
+
+const (
+	ProviderGoogleAI string = "googleai"
+	ProviderOpenAI   string = "openai"
+	ProviderOllama   string = "ollama"
+	ProviderFake     string = "fake"
+)
+
+// LLMProviderFactory builds the generation model for the provider from the config
+type LLMProviderFactory func(ctx context.Context, cfg config.Config) (llms.Model, error)
+
+// EmbedderProviderFactory builds the embedder client for the provider from the config
+type EmbedderProviderFactory func(ctx context.Context, cfg config.Config) (embeddings.EmbedderClient, error)
+
+var (
+	providersMu       sync.RWMutex
+	llmProviders      = map[string]LLMProviderFactory{}
+	embedderProviders = map[string]EmbedderProviderFactory{}
+)
+
+func init() {
+	RegisterLLMProvider(ProviderGoogleAI, func(ctx context.Context, cfg config.Config) (llms.Model, error) {
+		return newGoogleAI(ctx, cfg)
+	})
+	RegisterEmbedderProvider(ProviderGoogleAI, func(ctx context.Context, cfg config.Config) (embeddings.EmbedderClient, error) {
+		return newGoogleAI(ctx, cfg)
+	})
+
+	RegisterLLMProvider(ProviderOpenAI, func(ctx context.Context, cfg config.Config) (llms.Model, error) {
+		return newOpenAI(cfg)
+	})
+	RegisterEmbedderProvider(ProviderOpenAI, func(ctx context.Context, cfg config.Config) (embeddings.EmbedderClient, error) {
+		return newOpenAI(cfg)
+	})
+
+	RegisterLLMProvider(ProviderOllama, func(ctx context.Context, cfg config.Config) (llms.Model, error) {
+		return newOllama(cfg, cfg.LLMModel)
+	})
+	RegisterEmbedderProvider(ProviderOllama, func(ctx context.Context, cfg config.Config) (embeddings.EmbedderClient, error) {
+		return newOllama(cfg, cfg.EmbeddingModel)
+	})
+
+	RegisterLLMProvider(ProviderFake, func(ctx context.Context, cfg config.Config) (llms.Model, error) {
+		return NewFakeLLM(), nil
+	})
+	RegisterEmbedderProvider(ProviderFake, func(ctx context.Context, cfg config.Config) (embeddings.EmbedderClient, error) {
+		return NewFakeEmbedderClient(), nil
+	})
+}
+
+// RegisterLLMProvider makes the generation model provider available by name; registering the same name twice replaces the factory
+func RegisterLLMProvider(name string, factory LLMProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	llmProviders[name] = factory
+}
+
+// RegisterEmbedderProvider makes the embedding provider available by name; registering the same name twice replaces the factory
+func RegisterEmbedderProvider(name string, factory EmbedderProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	embedderProviders[name] = factory
+}
+
+// NewLLM builds the generation model selected by cfg.LLMProvider
+func NewLLM(ctx context.Context, cfg config.Config) (llms.Model, error) {
+	providersMu.RLock()
+	factory, ok := llmProviders[cfg.LLMProvider]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown llm provider: '%s'", cfg.LLMProvider)
+	}
+	return factory(ctx, cfg)
+}
+
+// NewEmbedderClient builds the embedder client selected by cfg.EmbeddingProvider
+func NewEmbedderClient(ctx context.Context, cfg config.Config) (embeddings.EmbedderClient, error) {
+	providersMu.RLock()
+	factory, ok := embedderProviders[cfg.EmbeddingProvider]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown embedding provider: '%s'", cfg.EmbeddingProvider)
+	}
+	return factory(ctx, cfg)
+}
+
+func newGoogleAI(ctx context.Context, cfg config.Config) (*googleai.GoogleAI, error) {
+	opts := []googleai.Option{googleai.WithAPIKey(cfg.GoogleAiApiKey)}
+	if len(cfg.LLMModel) > 0 {
+		opts = append(opts, googleai.WithDefaultModel(cfg.LLMModel))
+	}
+	if len(cfg.EmbeddingModel) > 0 {
+		opts = append(opts, googleai.WithDefaultEmbeddingModel(cfg.EmbeddingModel))
+	}
+	return googleai.New(ctx, opts...)
+}
+
+func newOpenAI(cfg config.Config) (*openai.LLM, error) {
+	opts := []openai.Option{openai.WithToken(cfg.OpenAIApiKey)}
+	if len(cfg.LLMModel) > 0 {
+		opts = append(opts, openai.WithModel(cfg.LLMModel))
+	}
+	if len(cfg.EmbeddingModel) > 0 {
+		opts = append(opts, openai.WithEmbeddingModel(cfg.EmbeddingModel))
+	}
+	if len(cfg.OpenAIBaseURL) > 0 {
+		opts = append(opts, openai.WithBaseURL(cfg.OpenAIBaseURL))
+	}
+	return openai.New(opts...)
+}
+
+// ollama serves both generation and embeddings from the model it was created with
+func newOllama(cfg config.Config, model string) (*ollama.LLM, error) {
+	opts := []ollama.Option{ollama.WithServerURL(cfg.OllamaServerURL)}
+	if len(model) > 0 {
+		opts = append(opts, ollama.WithModel(model))
+	}
+	return ollama.New(opts...)
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+const fakeEmbeddingDimensions int = 768
+
+// FakeLLM is an offline generation model for local runs and tests; it echoes the last text part of the conversation
+// and streams it word by word when asked to
+type FakeLLM struct{}
+
+func NewFakeLLM() *FakeLLM {
+	return &FakeLLM{}
+}
+
+func (f *FakeLLM) GenerateContent(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	if len(messages) == 0 {
+		return nil, errors.New("no messages provided")
+	}
+
+	content := ""
+	for _, part := range messages[len(messages)-1].Parts {
+		if textPart, ok := part.(llms.TextContent); ok {
+			content = textPart.Text
+		}
+	}
+
+	opts := llms.CallOptions{}
+	for _, opt := range options {
+		opt(&opts)
+	}
+	if opts.StreamingFunc != nil {
+		for _, word := range strings.SplitAfter(content, " ") {
+			if err := opts.StreamingFunc(ctx, []byte(word)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &llms.ContentResponse{
+		Choices: []*llms.ContentChoice{
+			{Content: content},
+		},
+	}, nil
+}
+
+func (f *FakeLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, f, prompt, options...)
+}
+
+// FakeEmbedderClient is an offline embedder for local runs and tests; it hashes the words of a text into a normalized vector
+// so texts sharing words land close to each other
+type FakeEmbedderClient struct{}
+
+func NewFakeEmbedderClient() *FakeEmbedderClient {
+	return &FakeEmbedderClient{}
+}
+
+func (f *FakeEmbedderClient) CreateEmbedding(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for _, text := range texts {
+		vector := make([]float32, fakeEmbeddingDimensions)
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			h := fnv.New32a()
+			h.Write([]byte(word))
+			vector[h.Sum32()%uint32(fakeEmbeddingDimensions)] += 1
+		}
+
+		var norm float64
+		for _, v := range vector {
+			norm += float64(v * v)
+		}
+		if norm > 0 {
+			norm = math.Sqrt(norm)
+			for i := range vector {
+				vector[i] = float32(float64(vector[i]) / norm)
+			}
+		}
+		vectors = append(vectors, vector)
+	}
+	return vectors, nil
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/config"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestFakeProviders(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Config{LLMProvider: ProviderFake, EmbeddingProvider: ProviderFake}
+
+	llm, err := NewLLM(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed := []string{}
+	answer, err := llms.GenerateFromSinglePrompt(ctx, llm, "the oceans are warming", llms.WithStreamingFunc(func(_ context.Context, chunk []byte) error {
+		streamed = append(streamed, string(chunk))
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "the oceans are warming" {
+		t.Errorf("answer is '%s', want the prompt echoed", answer)
+	}
+	if got := strings.Join(streamed, "|"); got != "the |oceans |are |warming" {
+		t.Errorf("streamed '%s', want the answer word by word", got)
+	}
+
+	embedder, err := NewEmbedderClient(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vectors, err := embedder.CreateEmbedding(ctx, []string{"sea level rise", "Sea level rise", "sea ice", "glacier melt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot := func(a, b []float32) float64 {
+		var sum float64
+		for i := range a {
+			sum += float64(a[i] * b[i])
+		}
+		return sum
+	}
+	if norm := dot(vectors[0], vectors[0]); math.Abs(norm-1) > 1e-6 {
+		t.Errorf("norm is '%v', want '1'", norm)
+	}
+	if similarity := dot(vectors[0], vectors[1]); math.Abs(similarity-1) > 1e-6 {
+		t.Errorf("similarity of the same words is '%v', want '1'", similarity)
+	}
+	// the texts sharing words are closer than the ones sharing none
+	if shared, none := dot(vectors[0], vectors[2]), dot(vectors[0], vectors[3]); shared <= none {
+		t.Errorf("similarity of the texts sharing a word is '%v', want above '%v'", shared, none)
+	}
+}
+
+func TestUnknownProvider(t *testing.T) {
+	ctx := context.Background()
+	if _, err := NewLLM(ctx, config.Config{LLMProvider: "unknown"}); err == nil {
+		t.Error("llm of the unknown provider is built")
+	}
+	if _, err := NewEmbedderClient(ctx, config.Config{EmbeddingProvider: "unknown"}); err == nil {
+		t.Error("embedder of the unknown provider is built")
+	}
+}
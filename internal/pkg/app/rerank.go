@@ -0,0 +1,121 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	log "github.com/sirupsen/logrus"
+	"github.com/tmc/langchaingo/llms"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	RerankerNone string = "none"
+	RerankerLLM  string = "llm"
+)
+
+// Reranker reorders the search results by their relevance to the query, most relevant first, setting the entries relevance
+type Reranker interface {
+	Rerank(ctx context.Context, query string, entries []model.SearchResultsEntry) ([]model.SearchResultsEntry, error)
+}
+
+// newReranker builds the reranker selected by name; nil when reranking is off
+func newReranker(name string, llm llms.Model) (Reranker, error) {
+	switch name {
+	case "", RerankerNone:
+		return nil, nil
+	case RerankerLLM:
+		return NewLLMReranker(llm), nil
+	}
+	return nil, fmt.Errorf("unknown reranker: '%s'", name)
+}
+
+// RerankEnabled tells whether the search results are reranked
+func (app *App) RerankEnabled() bool {
+	return app.reranker != nil
+}
+
+// Rerank reorders the search results by relevance to the query and keeps the top numDocuments;
+// the results are returned as is when no reranker is configured
+func (app *App) Rerank(ctx context.Context, query string, results model.SearchResults, numDocuments int) (model.SearchResults, error) {
+	if app.reranker == nil || len(results.Entries) == 0 {
+		return results, nil
+	}
+
+	entries, err := app.reranker.Rerank(ctx, query, results.Entries)
+	if err != nil {
+		log.Error(err)
+		return model.SearchResults{}, err
+	}
+	if len(entries) > numDocuments {
+		entries = entries[:numDocuments]
+	}
+	return model.SearchResults{
+		Entries: entries,
+	}, nil
+}
+
+// LLMReranker asks the generation model to score the relevance of every passage to the query
+type LLMReranker struct {
+	llm llms.Model
+}
+
+func NewLLMReranker(llm llms.Model) *LLMReranker {
+	return &LLMReranker{
+		llm: llm,
+	}
+}
+
+var rerankScoreRegexp = regexp.MustCompile(`(?m)^\s*\[?(\d+)\]?\s*[:=\-]\s*(\d+(?:\.\d+)?)`)
+
+func (r *LLMReranker) Rerank(ctx context.Context, query string, entries []model.SearchResultsEntry) ([]model.SearchResultsEntry, error) {
+	passages := []string{}
+	for i, entry := range entries {
+		passages = append(passages, fmt.Sprintf("[%d] %s", i+1, entry.PageContent))
+	}
+
+	resp, err := llms.GenerateFromSinglePrompt(ctx, r.llm, fmt.Sprintf(
+		`Rate how relevant each passage is for answering the question on a scale from 0 to 10, where 10 is the most relevant.
+		Reply with one line per passage in the format '<passage number>: <score>' and nothing else.
+		Question: %s
+		Passages:
+		%s`, query, strings.Join(passages, "\n")),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	reranked := append([]model.SearchResultsEntry{}, entries...)
+	scored := false
+	for _, match := range rerankScoreRegexp.FindAllStringSubmatch(resp, -1) {
+		idx, err := strconv.Atoi(match[1])
+		if err != nil || idx < 1 || idx > len(reranked) {
+			continue
+		}
+		score, err := strconv.ParseFloat(match[2], 32)
+		if err != nil {
+			continue
+		}
+		reranked[idx-1].Relevance = float32(score)
+		scored = true
+	}
+	if !scored {
+		// keep the search order rather than fail the query
+		log.Warnf("failed to parse the rerank scores: '%s'", resp)
+		return entries, nil
+	}
+
+	slices.SortStableFunc(reranked, func(a, b model.SearchResultsEntry) int {
+		if a.Relevance > b.Relevance {
+			return -1
+		} else if a.Relevance < b.Relevance {
+			return 1
+		}
+		return 0
+	})
+	return reranked, nil
+}
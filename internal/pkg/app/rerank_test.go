@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// replyLLM answers every prompt with the same reply
+type replyLLM struct {
+	reply string
+}
+
+func (l replyLLM) GenerateContent(_ context.Context, _ []llms.MessageContent, _ ...llms.CallOption) (*llms.ContentResponse, error) {
+	return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: l.reply}}}, nil
+}
+
+func (l replyLLM) Call(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+	return llms.GenerateFromSinglePrompt(ctx, l, prompt, options...)
+}
+
+func TestLLMRerankerScores(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    string
+		reranked string
+	}{
+		{
+			name:     "scores sort the passages",
+			reply:    "1: 2\n2: 9\n3: 5.5",
+			reranked: "b:9,c:5.5,a:2",
+		},
+		{
+			name:     "bracketed numbers and other separators",
+			reply:    "[1] = 3\n[2] - 1\n[3]: 7",
+			reranked: "c:7,a:3,b:1",
+		},
+		{
+			name:     "out of range indices are ignored",
+			reply:    "0: 10\n4: 10\n2: 6",
+			reranked: "b:6,a:0,c:0",
+		},
+		{
+			name:     "malformed lines are ignored",
+			reply:    "Here are the scores:\n1: high\n3: 8\npassage two: 9",
+			reranked: "c:8,a:0,b:0",
+		},
+		{
+			name:     "no scores keep the search order",
+			reply:    "I can not rate these passages.",
+			reranked: "a:0,b:0,c:0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := []model.SearchResultsEntry{{PageContent: "a"}, {PageContent: "b"}, {PageContent: "c"}}
+			reranked, err := NewLLMReranker(replyLLM{reply: tt.reply}).Rerank(context.Background(), "query", entries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			scores := []string{}
+			for _, entry := range reranked {
+				scores = append(scores, fmt.Sprintf("%s:%v", entry.PageContent, entry.Relevance))
+			}
+			if got := strings.Join(scores, ","); got != tt.reranked {
+				t.Errorf("reranked is '%s', want '%s'", got, tt.reranked)
+			}
+		})
+	}
+}
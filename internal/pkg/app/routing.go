@@ -0,0 +1,168 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	pgv "github.com/pgvector/pgvector-go"
+	"github.com/tmc/langchaingo/schema"
+)
+
+const (
+	MetadataRoutingKindFieldName string = "routing_kind"
+
+	// the file summary, given on upload or generated
+	RoutingKindSummary string = "summary"
+	// the summary of a part of the file
+	RoutingKindSection string = "section_summary"
+	// the key terms of the file
+	RoutingKindKeywords string = "keywords"
+	// a question the file answers
+	RoutingKindQuestion string = "question"
+
+	numberOfRoutingQuestions int = 10
+)
+
+// RoutingOptions drive which file collections the search looks into
+type RoutingOptions struct {
+	// the number of the routing entries closest to the query to take the files from; 0 takes them all
+	Depth int
+	// the most files searched
+	Namespaces int
+	// the routing entries farther from the query than the cosine distance are ignored; 0 ignores none
+	MaxScore float32
+	// skips the routing and searches every file; meant for the small knowledge bases
+	All bool
+}
+
+var ErrInvalidRoutingOptions = errors.New("invalid routing options")
+
+// ValidateRoutingOptions checks the options are within the bounds; the error wraps ErrInvalidRoutingOptions
+func ValidateRoutingOptions(opts RoutingOptions) error {
+	if opts.Depth < 0 {
+		return fmt.Errorf("%w: routing depth must not be negative", ErrInvalidRoutingOptions)
+	}
+	if opts.Namespaces <= 0 && !opts.All {
+		return fmt.Errorf("%w: routing namespaces must be positive", ErrInvalidRoutingOptions)
+	}
+	if opts.MaxScore < 0 || opts.MaxScore > 2 {
+		return fmt.Errorf("%w: routing max score must be between 0 and 2", ErrInvalidRoutingOptions)
+	}
+	return nil
+}
+
+// list markers the llm puts ahead of the questions, like "1." or "-"
+var listMarkerRegexp = regexp.MustCompile(`^\s*(\d+[.)]|[-*•])\s*`)
+
+// generateRoutingEntries turns the summaries of the file parts into the entries routing the queries to the file: the part summaries
+// themselves, the keywords and the questions the file answers
+func (app *App) generateRoutingEntries(ctx context.Context, partSummaries []string) ([]model.RoutingEntry, error) {
+	entries := []model.RoutingEntry{}
+	for _, partSummary := range partSummaries {
+		entries = append(entries, model.RoutingEntry{Kind: RoutingKindSection, PageContent: partSummary})
+	}
+
+	overview := strings.Join(partSummaries, "\n\n")
+	keywords, err := app.GenerateFromSinglePrompt(ctx, fmt.Sprintf("List up to 30 keywords and key phrases of the document described below: the topics, the terms, the places, the organisations and the models it refers to. Return only the comma separated list.\nDOCUMENT:\n%s", overview))
+	if err != nil {
+		return nil, err
+	}
+	if keywords = strings.TrimSpace(keywords); len(keywords) > 0 {
+		entries = append(entries, model.RoutingEntry{Kind: RoutingKindKeywords, PageContent: keywords})
+	}
+
+	questions, err := app.GenerateFromSinglePrompt(ctx, fmt.Sprintf("Write %d different questions the document described below answers. Each question must be understandable on its own. Return only the questions, one per line.\nDOCUMENT:\n%s", numberOfRoutingQuestions, overview))
+	if err != nil {
+		return nil, err
+	}
+	for _, question := range strings.Split(questions, "\n") {
+		if question = strings.TrimSpace(listMarkerRegexp.ReplaceAllString(question, "")); len(question) > 0 {
+			entries = append(entries, model.RoutingEntry{Kind: RoutingKindQuestion, PageContent: question})
+		}
+	}
+	return entries, nil
+}
+
+// routingDocuments makes the documents indexed in the default collection for the routing entries
+func routingDocuments(fileName string, entries []model.RoutingEntry) []schema.Document {
+	docs := []schema.Document{}
+	for _, entry := range entries {
+		doc := schema.Document{
+			PageContent: removeLBR(entry.PageContent),
+			Metadata: map[string]any{
+				MetadataCollectionFieldName:  fileName,
+				MetadataRoutingKindFieldName: entry.Kind,
+			},
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// routeQuery returns the names of the file collections to search for the query. The files are picked from the routing entries
+// of the default collection closest to the query, each file ranked by its best matching entry, so the files with many entries
+// do not crowd out the others. The entries less similar to the query than the score threshold are ignored the way the pgvector store does
+func (app *App) routeQuery(ctx context.Context, queryVector []float32, opts SearchOptions) ([]string, error) {
+	if opts.Routing.All {
+		return app.collectionNames(ctx)
+	}
+
+	// no limit on the entries when the depth is not set
+	var depth *int
+	if opts.Routing.Depth > 0 {
+		depth = &opts.Routing.Depth
+	}
+	filters, args := filtersSQL(opts.Filters, []any{
+		DefaultCollectionName, pgv.NewVector(queryVector), depth, opts.Routing.MaxScore, opts.Routing.Namespaces, opts.ScoreThreshold, len(queryVector),
+	})
+	rows, err := app.pgpool.Query(ctx, `SELECT entry.name, MIN(entry.distance) AS distance
+	FROM (
+		SELECT emb.cmetadata ->> 'collection_name' AS name, emb.embedding <=> $2 AS distance
+		FROM langchain_pg_embedding AS emb JOIN langchain_pg_collection AS coll ON emb.collection_id = coll.uuid
+		WHERE coll.name = $1 AND vector_dims(emb.embedding) = $7`+filters+`
+		ORDER BY distance
+		LIMIT $3
+	) AS entry
+	WHERE ($4::float8 = 0 OR entry.distance <= $4::float8) AND ($6::float8 = 0 OR entry.distance < 1 - $6::float8)
+	GROUP BY entry.name
+	ORDER BY distance
+	LIMIT $5`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		var distance float64
+		if err := rows.Scan(&name, &distance); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// collectionNames returns the names of all the file collections
+func (app *App) collectionNames(ctx context.Context) ([]string, error) {
+	rows, err := app.pgpool.Query(ctx, `SELECT name FROM langchain_pg_collection WHERE name <> $1 ORDER BY name`, DefaultCollectionName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// migrations holds the tables the app keeps next to the langchain ones;
+// statements must be idempotent as they run on every start, after the langchain tables are created
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS conversation_turn (
+	conversation_id uuid NOT NULL,
+	turn int NOT NULL,
+	query text NOT NULL,
+	improved_prompt text NOT NULL,
+	answer text NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (conversation_id, turn))`,
+	`CREATE TABLE IF NOT EXISTS ingestion_job (
+	id uuid NOT NULL,
+	filename varchar NOT NULL,
+	content_type varchar NOT NULL,
+	summary text NOT NULL,
+	content bytea,
+	status varchar NOT NULL,
+	chunks_total int NOT NULL DEFAULT 0,
+	chunks_indexed int NOT NULL DEFAULT 0,
+	error text,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	updated_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (id))`,
+	`CREATE INDEX IF NOT EXISTS ingestion_job_status ON ingestion_job (status, created_at)`,
+	`ALTER TABLE ingestion_job ADD COLUMN IF NOT EXISTS chunking json`,
+	`ALTER TABLE ingestion_job ADD COLUMN IF NOT EXISTS source_url text`,
+	`ALTER TABLE ingestion_job ADD COLUMN IF NOT EXISTS tags json`,
+	`CREATE TABLE IF NOT EXISTS answer_cache (
+	id uuid NOT NULL,
+	query text NOT NULL,
+	options text NOT NULL,
+	embedding vector NOT NULL,
+	answer json NOT NULL,
+	documents text[] NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	expires_at timestamptz NOT NULL,
+	PRIMARY KEY (id))`,
+	`CREATE INDEX IF NOT EXISTS answer_cache_documents ON answer_cache USING gin (documents)`,
+	`CREATE TABLE IF NOT EXISTS embedding_cache (
+	model varchar NOT NULL,
+	content_hash varchar NOT NULL,
+	embedding vector NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	used_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (model, content_hash))`,
+	`CREATE INDEX IF NOT EXISTS embedding_cache_used_at ON embedding_cache (used_at)`,
+	`ALTER TABLE ingestion_job ADD COLUMN IF NOT EXISTS chunks_cached int NOT NULL DEFAULT 0`,
+	`ALTER TABLE ingestion_job ADD COLUMN IF NOT EXISTS chunks_added int NOT NULL DEFAULT 0`,
+	`ALTER TABLE ingestion_job ADD COLUMN IF NOT EXISTS chunks_removed int NOT NULL DEFAULT 0`,
+	`ALTER TABLE ingestion_job ADD COLUMN IF NOT EXISTS chunks_unchanged int NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS conversation_turn_created_at ON conversation_turn (created_at)`,
+}
+
+// backgroundIndex is an index over the existing data, which may take long to build
+type backgroundIndex struct {
+	name       string
+	definition string
+}
+
+// backgroundIndexes are built concurrently after the start, so neither the liveness probe nor the writes wait for them;
+// the queries work without them meanwhile, only slower
+var backgroundIndexes = []backgroundIndex{
+	{
+		name:       "langchain_pg_embedding_document_fts",
+		definition: `ON langchain_pg_embedding USING gin (to_tsvector('english', document))`,
+	},
+}
+
+func (app *App) migrate(ctx context.Context) error {
+	for _, statement := range migrations {
+		if _, err := app.pgpool.Exec(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildIndexes builds the missing background indexes one by one; the failures are logged and the index is built again on the next start
+func (app *App) buildIndexes(ctx context.Context) {
+	for _, index := range backgroundIndexes {
+		if err := app.buildIndex(ctx, index); err != nil {
+			log.Errorf("failed to build the index '%s': %s", index.name, err)
+		}
+	}
+}
+
+// buildIndex builds the index unless it is there; the index left invalid by an interrupted build is dropped and built again.
+// The concurrent builds can not run in a transaction, the pool runs the statements one by one
+func (app *App) buildIndex(ctx context.Context, index backgroundIndex) error {
+	var valid bool
+	err := app.pgpool.QueryRow(ctx, `SELECT indisvalid FROM pg_index WHERE indexrelid = to_regclass($1)`, index.name).Scan(&valid)
+	if err == nil && valid {
+		return nil
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if err == nil {
+		log.Warnf("dropping the invalid index '%s'", index.name)
+		if _, err := app.pgpool.Exec(ctx, fmt.Sprintf(`DROP INDEX CONCURRENTLY IF EXISTS %s`, index.name)); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("building the index '%s'", index.name)
+	if _, err := app.pgpool.Exec(ctx, fmt.Sprintf(`CREATE INDEX CONCURRENTLY IF NOT EXISTS %s %s`, index.name, index.definition)); err != nil {
+		return err
+	}
+	log.Infof("index '%s' is built", index.name)
+	return nil
+}
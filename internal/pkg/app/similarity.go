@@ -0,0 +1,45 @@
+package app
+
+import (
+	"context"
+
+	pgv "github.com/pgvector/pgvector-go"
+	"github.com/tmc/langchaingo/schema"
+)
+
+// namespaceSearcher looks for the chunks of the file collection closest to the query; the app searches the database
+type namespaceSearcher interface {
+	similaritySearch(ctx context.Context, collectionName string, queryVector []float32, numDocuments int, opts SearchOptions) ([]schema.Document, [][]float32, error)
+}
+
+// similaritySearch returns the collection chunks closest to the query the way the pgvector store does, the score is the cosine distance,
+// together with the chunks embeddings. Unlike the store, the metadata filters are passed as the query arguments and support more than the equality
+func (app *App) similaritySearch(ctx context.Context, collectionName string, queryVector []float32, numDocuments int, opts SearchOptions) ([]schema.Document, [][]float32, error) {
+	filters, args := filtersSQL(opts.Filters, []any{
+		collectionName, pgv.NewVector(queryVector), numDocuments, opts.ScoreThreshold, len(queryVector),
+	})
+	// the vectors of another embedding model can not be compared
+	rows, err := app.pgpool.Query(ctx, `SELECT emb.document, emb.cmetadata, emb.embedding, emb.embedding <=> $2 AS distance
+	FROM langchain_pg_embedding AS emb JOIN langchain_pg_collection AS coll ON emb.collection_id = coll.uuid
+	WHERE coll.name = $1 AND vector_dims(emb.embedding) = $5
+		AND ($4::float8 = 0 OR emb.embedding <=> $2 < 1 - $4::float8)`+filters+`
+	ORDER BY distance
+	LIMIT $3`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	docs := []schema.Document{}
+	vectors := [][]float32{}
+	for rows.Next() {
+		doc := schema.Document{}
+		vector := pgv.Vector{}
+		if err := rows.Scan(&doc.PageContent, &doc.Metadata, &vector, &doc.Score); err != nil {
+			return nil, nil, err
+		}
+		docs = append(docs, doc)
+		vectors = append(vectors, vector.Slice())
+	}
+	return docs, vectors, rows.Err()
+}
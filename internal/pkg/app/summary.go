@@ -0,0 +1,78 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tmc/langchaingo/textsplitter"
+)
+
+const (
+	MetadataSummaryGeneratedFieldName string = "summary_generated"
+
+	// the text given to the llm at once, in characters; longer documents are summarized part by part and the parts are combined
+	summaryPartSize int = 12000
+	// the most parts summarized for a single document; the parts of the longer documents are sampled evenly
+	maxSummaryParts int = 24
+	// the summary is used to route the queries, so it is kept short
+	maxSummaryLength int = 1000
+)
+
+var ErrEmptyDocument = errors.New("document has no text to summarize")
+
+// summarizeParts is the map step of the summary, which is written the map-reduce way: the document is split into the parts
+// the llm takes at once and each one is summarized; the parts summaries route the queries to the document as well
+func (app *App) summarizeParts(ctx context.Context, body string) ([]string, error) {
+	body = strings.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, ErrEmptyDocument
+	}
+
+	parts, err := textsplitter.NewRecursiveCharacter(
+		textsplitter.WithChunkSize(summaryPartSize),
+		textsplitter.WithChunkOverlap(0),
+	).SplitText(body)
+	if err != nil {
+		return nil, err
+	}
+
+	partSummaries := []string{}
+	for _, part := range samplePartsEvenly(parts, maxSummaryParts) {
+		partSummary, err := app.GenerateFromSinglePrompt(ctx, partSummaryPrompt(part))
+		if err != nil {
+			return nil, err
+		}
+		partSummaries = append(partSummaries, strings.TrimSpace(partSummary))
+	}
+	return partSummaries, nil
+}
+
+// summaryFromParts is the reduce step of the summary
+func (app *App) summaryFromParts(ctx context.Context, partSummaries []string) (string, error) {
+	if len(partSummaries) == 0 {
+		return "", ErrEmptyDocument
+	}
+	return app.GenerateFromSinglePrompt(ctx, summaryPrompt(strings.Join(partSummaries, "\n\n")))
+}
+
+// samplePartsEvenly keeps at most n parts spread evenly over the document, so the summary covers it from the start to the end
+func samplePartsEvenly(parts []string, n int) []string {
+	if len(parts) <= n {
+		return parts
+	}
+	sampled := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		sampled = append(sampled, parts[i*len(parts)/n])
+	}
+	return sampled
+}
+
+func partSummaryPrompt(part string) string {
+	return fmt.Sprintf("Summarize the following part of a document in a few sentences. Keep the topics, places, time periods and the key figures it covers. Return only the summary.\nTEXT:\n%s", part)
+}
+
+func summaryPrompt(text string) string {
+	return fmt.Sprintf("Write a summary of the document from the text below. The summary is used to decide whether the document can answer a question, so name the topics, places, time periods and the key findings it covers. The summary should not exceed %d characters. Do not add any formatting. Return only the summary.\nTEXT:\n%s", maxSummaryLength, text)
+}
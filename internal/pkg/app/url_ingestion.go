@@ -0,0 +1,228 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// the largest page or file fetched
+	maxFetchSize int64 = 50 << 20
+	// the most urls queued from a single sitemap, the nested sitemaps included
+	maxSitemapURLs int = 1000
+	// how many levels of the sitemap indexes are followed
+	maxSitemapDepth int = 2
+
+	fetchUserAgent string = "climate_mate"
+)
+
+var (
+	ErrInvalidURL   = errors.New("invalid url")
+	ErrFetchFailed  = errors.New("failed to fetch the url")
+	ErrEmptySitemap = errors.New("sitemap lists no urls")
+
+	errNonPublicAddress = errors.New("non public address")
+
+	fetchClient = newFetchClient()
+)
+
+// sitemap reads both the urlset and the sitemapindex documents
+type sitemap struct {
+	URLs     []sitemapLocation `xml:"url"`
+	Sitemaps []sitemapLocation `xml:"sitemap"`
+}
+
+type sitemapLocation struct {
+	Loc string `xml:"loc"`
+}
+
+// EnqueueURLIngestion queues the page or the file at the url for ingestion. The document is named after the url without the scheme
+// and the url is kept in the metadata of the chunks. The summary is generated when empty
+func (app *App) EnqueueURLIngestion(ctx context.Context, pageURL, summary string, chunking model.ChunkingOptions, tags map[string]any) (model.Job, error) {
+	name, err := documentNameFromURL(pageURL)
+	if err != nil {
+		return model.Job{}, err
+	}
+	return app.enqueueJob(ctx, name, "", summary, nil, pageURL, chunking, tags)
+}
+
+// EnqueueSitemapIngestion fetches the sitemap and queues every url it lists for ingestion the way EnqueueURLIngestion does;
+// the sitemap indexes are followed. Each page gets its own generated summary
+func (app *App) EnqueueSitemapIngestion(ctx context.Context, sitemapURL string, chunking model.ChunkingOptions, tags map[string]any) ([]model.Job, error) {
+	if err := validateChunkingOptions(chunking); err != nil {
+		return nil, err
+	}
+	if err := ValidateTags(tags); err != nil {
+		return nil, err
+	}
+	if _, err := documentNameFromURL(sitemapURL); err != nil {
+		return nil, err
+	}
+
+	urls, err := fetchSitemap(ctx, sitemapURL, maxSitemapDepth, map[string]struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, ErrEmptySitemap
+	}
+
+	jobs := []model.Job{}
+	for _, pageURL := range urls {
+		job, err := app.EnqueueURLIngestion(ctx, pageURL, "", chunking, tags)
+		if errors.Is(err, ErrInvalidURL) {
+			log.Warnf("skipping the sitemap entry: %s", err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// documentNameFromURL names the document by the host and the path of the url; the scheme is left out so the name fits the url path
+func documentNameFromURL(pageURL string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(pageURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return "", fmt.Errorf("%w: '%s'", ErrInvalidURL, pageURL)
+	}
+	name := u.Host + strings.TrimSuffix(u.EscapedPath(), "/")
+	if len(u.RawQuery) > 0 {
+		name += "?" + u.RawQuery
+	}
+	return name, nil
+}
+
+// newFetchClient returns the client fetching the urls given by the users. The address is checked once resolved, right before
+// the connection is made, so neither the redirects nor the names resolving to the internal addresses reach the cluster network
+// or the metadata server
+func newFetchClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", errNonPublicAddress, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would make the dialer check the proxy address instead of the target one
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: time.Minute, Transport: transport}
+}
+
+// isPublicIP tells whether the address is routable on the internet: the loopback, private, link local (the metadata server
+// among them), multicast and unspecified addresses are not
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// fetchURL downloads the url and returns the content with its mime type; the type is sniffed when the server does not tell
+func fetchURL(ctx context.Context, pageURL string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: '%s'", ErrInvalidURL, pageURL)
+	}
+	req.Header.Set("User-Agent", fetchUserAgent)
+
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%w: '%s' replied %s", ErrFetchFailed, pageURL, resp.Status)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrFetchFailed, err)
+	}
+	if int64(len(content)) > maxFetchSize {
+		return nil, "", fmt.Errorf("%w: '%s' is larger than %d bytes", ErrFetchFailed, pageURL, maxFetchSize)
+	}
+
+	contentType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || contentType == "application/octet-stream" {
+		contentType, _, _ = mime.ParseMediaType(http.DetectContentType(content))
+	}
+	if contentType == "application/xhtml+xml" {
+		contentType = "text/html"
+	}
+	return content, contentType, nil
+}
+
+// fetchSitemap returns the page urls listed in the sitemap, following the nested sitemaps up to the depth
+func fetchSitemap(ctx context.Context, sitemapURL string, depth int, seen map[string]struct{}) ([]string, error) {
+	content, _, err := fetchURL(ctx, sitemapURL)
+	if err != nil {
+		return nil, err
+	}
+	// sitemaps are often served gzipped as files
+	if bytes.HasPrefix(content, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrFetchFailed, err)
+		}
+		content, err = io.ReadAll(io.LimitReader(reader, maxFetchSize))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrFetchFailed, err)
+		}
+	}
+
+	parsed := sitemap{}
+	if err := xml.Unmarshal(content, &parsed); err != nil {
+		return nil, fmt.Errorf("%w: '%s' is not a sitemap", ErrFetchFailed, sitemapURL)
+	}
+
+	urls := []string{}
+	for _, location := range parsed.URLs {
+		loc := strings.TrimSpace(location.Loc)
+		if _, ok := seen[loc]; ok || len(loc) == 0 {
+			continue
+		}
+		if len(seen) >= maxSitemapURLs {
+			log.Warnf("sitemap '%s' lists more than %d urls, the rest is skipped", sitemapURL, maxSitemapURLs)
+			return urls, nil
+		}
+		seen[loc] = struct{}{}
+		urls = append(urls, loc)
+	}
+	if depth == 0 {
+		return urls, nil
+	}
+	for _, location := range parsed.Sitemaps {
+		if len(seen) >= maxSitemapURLs {
+			break
+		}
+		nested, err := fetchSitemap(ctx, strings.TrimSpace(location.Loc), depth-1, seen)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, nested...)
+	}
+	return urls, nil
+}
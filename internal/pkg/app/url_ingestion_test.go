@@ -0,0 +1,319 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// allowLoopbackFetch lets fetchURL reach the test servers, which listen on the loopback
+func allowLoopbackFetch(t *testing.T) {
+	client := fetchClient
+	fetchClient = &http.Client{Timeout: time.Minute}
+	t.Cleanup(func() { fetchClient = client })
+}
+
+func urlset(urls ...string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)
+	for _, u := range urls {
+		fmt.Fprintf(&b, "<url><loc>%s</loc></url>", u)
+	}
+	b.WriteString(`</urlset>`)
+	return b.String()
+}
+
+func sitemapIndex(sitemaps ...string) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)
+	for _, s := range sitemaps {
+		fmt.Fprintf(&b, "<sitemap><loc>%s</loc></sitemap>", s)
+	}
+	b.WriteString(`</sitemapindex>`)
+	return b.String()
+}
+
+func gzipped(t *testing.T, content string) []byte {
+	var b bytes.Buffer
+	writer := gzip.NewWriter(&b)
+	if _, err := writer.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestFetchURL(t *testing.T) {
+	allowLoopbackFetch(t)
+
+	pdf := []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		if r.UserAgent() != fetchUserAgent {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html><body><p>climate</p></body></html>")
+	})
+	mux.HandleFunc("/xhtml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xhtml+xml")
+		fmt.Fprint(w, "<html><body><p>climate</p></body></html>")
+	})
+	mux.HandleFunc("/report.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write(pdf)
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(pdf)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+	}{
+		{name: "html page", path: "/page", contentType: "text/html"},
+		{name: "xhtml page", path: "/xhtml", contentType: "text/html"},
+		{name: "pdf file", path: "/report.pdf", contentType: "application/pdf"},
+		{name: "pdf file of unknown type", path: "/download", contentType: "application/pdf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, contentType, err := fetchURL(context.Background(), server.URL+tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if contentType != tt.contentType {
+				t.Errorf("content type is '%s', want '%s'", contentType, tt.contentType)
+			}
+			if len(content) == 0 {
+				t.Error("content is empty")
+			}
+		})
+	}
+}
+
+func TestFetchURLFails(t *testing.T) {
+	allowLoopbackFetch(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprint(w, "<html><body>error</body></html>")
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/missing", "/forbidden", "/created", "/broken"} {
+		t.Run(path, func(t *testing.T) {
+			_, _, err := fetchURL(context.Background(), server.URL+path)
+			if !errors.Is(err, ErrFetchFailed) {
+				t.Errorf("error is '%v', want ErrFetchFailed", err)
+			}
+		})
+	}
+}
+
+func TestFetchURLRejectsNonPublicAddresses(t *testing.T) {
+	requested := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+		fmt.Fprint(w, "secret")
+	}))
+	defer server.Close()
+
+	_, _, err := fetchURL(context.Background(), server.URL)
+	if !errors.Is(err, ErrFetchFailed) || !errors.Is(err, errNonPublicAddress) {
+		t.Errorf("error is '%v', want the non public address rejected", err)
+	}
+	if requested {
+		t.Error("the loopback server was requested")
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{ip: "8.8.8.8", public: true},
+		{ip: "35.209.21.126", public: true},
+		{ip: "2001:4860:4860::8888", public: true},
+		{ip: "127.0.0.1", public: false},
+		{ip: "::1", public: false},
+		{ip: "10.0.0.1", public: false},
+		{ip: "172.16.5.4", public: false},
+		{ip: "192.168.1.1", public: false},
+		{ip: "fd00::1", public: false},
+		{ip: "169.254.169.254", public: false},
+		{ip: "fe80::1", public: false},
+		{ip: "::ffff:127.0.0.1", public: false},
+		{ip: "0.0.0.0", public: false},
+		{ip: "224.0.0.1", public: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if public := isPublicIP(net.ParseIP(tt.ip)); public != tt.public {
+				t.Errorf("isPublicIP is %t, want %t", public, tt.public)
+			}
+		})
+	}
+}
+
+func TestFetchSitemap(t *testing.T) {
+	allowLoopbackFetch(t)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprint(w, urlset("https://example.org/a", " https://example.org/b ", "https://example.org/a", ""))
+		case "/sitemap.xml.gz":
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Write(gzipped(t, urlset("https://example.org/gz")))
+		case "/index.xml":
+			fmt.Fprint(w, sitemapIndex(server.URL+"/sitemap.xml", server.URL+"/sitemap.xml.gz", server.URL+"/nested-index.xml"))
+		case "/nested-index.xml":
+			fmt.Fprint(w, sitemapIndex(server.URL+"/deep.xml"))
+		case "/deep.xml":
+			fmt.Fprint(w, urlset("https://example.org/deep"))
+		case "/page.html":
+			fmt.Fprint(w, "<html><body>not a sitemap</body></html>")
+		case "/page.txt":
+			fmt.Fprint(w, "not a sitemap")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		path  string
+		depth int
+		urls  []string
+		err   error
+	}{
+		{name: "urlset", path: "/sitemap.xml", depth: maxSitemapDepth, urls: []string{"https://example.org/a", "https://example.org/b"}},
+		{name: "gzipped", path: "/sitemap.xml.gz", depth: maxSitemapDepth, urls: []string{"https://example.org/gz"}},
+		{
+			name:  "nested sitemap index",
+			path:  "/index.xml",
+			depth: maxSitemapDepth,
+			urls:  []string{"https://example.org/a", "https://example.org/b", "https://example.org/gz", "https://example.org/deep"},
+		},
+		{
+			name:  "nested sitemap index deeper than the depth",
+			path:  "/index.xml",
+			depth: 1,
+			urls:  []string{"https://example.org/a", "https://example.org/b", "https://example.org/gz"},
+		},
+		{name: "missing sitemap", path: "/missing.xml", depth: maxSitemapDepth, err: ErrFetchFailed},
+		// the html parses as a sitemap listing no urls
+		{name: "html page", path: "/page.html", depth: maxSitemapDepth},
+		{name: "not a sitemap", path: "/page.txt", depth: maxSitemapDepth, err: ErrFetchFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			urls, err := fetchSitemap(context.Background(), server.URL+tt.path, tt.depth, map[string]struct{}{})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error is '%v', want '%v'", err, tt.err)
+			}
+			if strings.Join(urls, ",") != strings.Join(tt.urls, ",") {
+				t.Errorf("urls are %v, want %v", urls, tt.urls)
+			}
+		})
+	}
+}
+
+func TestFetchSitemapURLCap(t *testing.T) {
+	allowLoopbackFetch(t)
+
+	urls := []string{}
+	for i := 0; i < maxSitemapURLs+10; i++ {
+		urls = append(urls, fmt.Sprintf("https://example.org/page-%d", i))
+	}
+	nestedRequested := false
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			// a sitemap may list both the pages and the nested sitemaps
+			sitemap := urlset(urls...)
+			sitemap = strings.TrimSuffix(sitemap, "</urlset>") + fmt.Sprintf("<sitemap><loc>%s/nested.xml</loc></sitemap></urlset>", server.URL)
+			fmt.Fprint(w, sitemap)
+		default:
+			nestedRequested = true
+			fmt.Fprint(w, urlset("https://example.org/nested"))
+		}
+	}))
+	defer server.Close()
+
+	fetched, err := fetchSitemap(context.Background(), server.URL+"/sitemap.xml", maxSitemapDepth, map[string]struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fetched) != maxSitemapURLs {
+		t.Errorf("%d urls are fetched, want %d", len(fetched), maxSitemapURLs)
+	}
+	if fetched[len(fetched)-1] != urls[maxSitemapURLs-1] {
+		t.Errorf("the last url is '%s', want '%s'", fetched[len(fetched)-1], urls[maxSitemapURLs-1])
+	}
+	if nestedRequested {
+		t.Error("the nested sitemap is fetched past the cap")
+	}
+}
+
+func TestDocumentNameFromURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		name string
+		err  error
+	}{
+		{url: "https://example.org/reports/2023.pdf", name: "example.org/reports/2023.pdf"},
+		{url: "http://example.org/reports/", name: "example.org/reports"},
+		{url: "https://example.org", name: "example.org"},
+		{url: "  https://example.org/a  ", name: "example.org/a"},
+		{url: "https://example.org:8443/a?page=2&lang=en", name: "example.org:8443/a?page=2&lang=en"},
+		{url: "https://example.org/a%20b", name: "example.org/a%20b"},
+		{url: "https://example.org/a#section", name: "example.org/a"},
+		{url: "ftp://example.org/a", err: ErrInvalidURL},
+		{url: "example.org/a", err: ErrInvalidURL},
+		{url: "https:///a", err: ErrInvalidURL},
+		{url: "://example.org", err: ErrInvalidURL},
+		{url: "", err: ErrInvalidURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			name, err := documentNameFromURL(tt.url)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error is '%v', want '%v'", err, tt.err)
+			}
+			if name != tt.name {
+				t.Errorf("name is '%s', want '%s'", name, tt.name)
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package config
 
-import "github.com/namsral/flag"
+import (
+	"time"
+
+	"github.com/namsral/flag"
+)
 
 type Config struct {
 	Port      string
@@ -12,7 +16,56 @@ type Config struct {
 	PGPort     string
 	PGDBName   string
 
-	GoogleAiApiKey string
+	PGMaxConns          int
+	PGMinConns          int
+	PGMaxConnLifetime   time.Duration
+	PGMaxConnIdleTime   time.Duration
+	PGHealthCheckPeriod time.Duration
+
+	LLMProvider       string
+	LLMModel          string
+	EmbeddingProvider string
+	EmbeddingModel    string
+
+	GoogleAiApiKey  string
+	OpenAIApiKey    string
+	OpenAIBaseURL   string
+	OllamaServerURL string
+
+	IngestionWorkers int
+	MaxUploadSize    int
+	RoutingEntries   bool
+
+	ChunkStrategy string
+	ChunkSize     int
+	ChunkOverlap  int
+	MinChunkSize  int
+
+	RoutingDepth         int
+	RoutingNamespaces    int
+	RoutingMaxScore      float64
+	SearchAllCollections bool
+	ScoreThreshold       float64
+
+	Reranker string
+
+	MaxSearchResults int
+
+	AnswerCacheTTL       time.Duration
+	AnswerCacheThreshold float64
+	ConversationTTL      time.Duration
+
+	APIKeys       string
+	JWKSFile      string
+	JWTIssuer     string
+	JWTAudience   string
+	JWTRolesClaim string
+	AnonymousRole string
+
+	RateLimits        string
+	TrustProxyHeaders bool
+	LLMDailyCalls     int
+	LLMDailyTokens    int
 }
 
 func (c *Config) Init() {
@@ -24,8 +77,56 @@ func (c *Config) Init() {
 	flag.StringVar(&c.PGHost, "pg_hostname", "localhost", "PG DB hostname")
 	flag.StringVar(&c.PGPort, "pg_port", "5432", "PG DB port")
 	flag.StringVar(&c.PGDBName, "pg_dbname", "", "PG DB name")
+	flag.IntVar(&c.PGMaxConns, "pg_max_conns", 10, "PG DB connection pool size")
+	flag.IntVar(&c.PGMinConns, "pg_min_conns", 0, "PG DB connections kept open when idle")
+	flag.DurationVar(&c.PGMaxConnLifetime, "pg_max_conn_lifetime", time.Hour, "PG DB connection lifetime before it is closed")
+	flag.DurationVar(&c.PGMaxConnIdleTime, "pg_max_conn_idle_time", 30*time.Minute, "PG DB idle connection lifetime before it is closed")
+	flag.DurationVar(&c.PGHealthCheckPeriod, "pg_health_check_period", time.Minute, "PG DB idle connections health check period")
+
+	flag.StringVar(&c.LLMProvider, "llm_provider", "googleai", "The provider of the generation model. One of googleai, openai, ollama or fake")
+	flag.StringVar(&c.LLMModel, "llm_model", "", "The generation model name; provider default when empty")
+	flag.StringVar(&c.EmbeddingProvider, "embedding_provider", "googleai", "The provider of the embedding model. One of googleai, openai, ollama or fake")
+	flag.StringVar(&c.EmbeddingModel, "embedding_model", "", "The embedding model name; provider default when empty")
 
 	flag.StringVar(&c.GoogleAiApiKey, "googleai_api_key", "", "GoogleAI API key")
+	flag.StringVar(&c.OpenAIApiKey, "openai_api_key", "", "OpenAI API key")
+	flag.StringVar(&c.OpenAIBaseURL, "openai_base_url", "", "OpenAI compatible API base URL; OpenAI default when empty")
+	flag.StringVar(&c.OllamaServerURL, "ollama_server_url", "http://localhost:11434", "Ollama server URL")
+
+	flag.IntVar(&c.IngestionWorkers, "ingestion_workers", 2, "The number of background workers processing the uploaded files")
+	flag.IntVar(&c.MaxUploadSize, "max_upload_size", 50, "The largest file the upload accepts, in MiB; every ingestion worker holds a file of up to this size in memory")
+	flag.BoolVar(&c.RoutingEntries, "routing_entries", true, "Generate the section summaries, keywords and questions of the uploaded files to route the queries by, next to the file summary")
+
+	flag.StringVar(&c.ChunkStrategy, "chunk_strategy", "recursive", "The default chunking strategy of the uploaded files. One of recursive, token, markdown or sentence")
+	flag.IntVar(&c.ChunkSize, "chunk_size", 1000, "The default chunk size; in tokens for the token strategy, in characters otherwise")
+	flag.IntVar(&c.ChunkOverlap, "chunk_overlap", 100, "The default chunk overlap; in tokens for the token strategy, in characters otherwise")
+	flag.IntVar(&c.MinChunkSize, "min_chunk_size", 250, "The default minimum chunk length in characters; shorter chunks are not indexed")
+
+	flag.IntVar(&c.RoutingDepth, "routing_depth", 20, "The default number of the summaries and routing entries closest to the query the files to search are picked from; 0 takes them all")
+	flag.IntVar(&c.RoutingNamespaces, "routing_namespaces", 2, "The default number of the files searched for the query")
+	flag.Float64Var(&c.RoutingMaxScore, "routing_max_score", 0, "The default max cosine distance of the routing entries to the query; 0 for no limit")
+	flag.Float64Var(&c.ScoreThreshold, "score_threshold", 0, "The default least similarity (1 - cosine distance) of the routing entries and the pages to the query, between 0 and 1; 0 for no limit")
+	flag.BoolVar(&c.SearchAllCollections, "search_all_collections", false, "Search every file by default instead of routing the query; meant for the small knowledge bases")
+
+	flag.StringVar(&c.Reranker, "reranker", "none", "The reranker of the search results fed into the answer. Either none, or llm")
+
+	flag.IntVar(&c.MaxSearchResults, "max_search_results", 50, "The most pages the search returns, and the most files a request can route the query to")
+
+	flag.DurationVar(&c.AnswerCacheTTL, "answer_cache_ttl", 24*time.Hour, "How long the answers to the new questions are reused for the similar questions; 0 disables the answer cache")
+	flag.DurationVar(&c.ConversationTTL, "conversation_ttl", 7*24*time.Hour, "How long the conversation turns are kept for the follow up questions; 0 keeps them forever")
+	flag.Float64Var(&c.AnswerCacheThreshold, "answer_cache_threshold", 0.95, "The least similarity (1 - cosine distance) of the question to a past one for its cached answer to be reused, above 0 and at most 1")
+
+	flag.StringVar(&c.APIKeys, "api_keys", "", "Comma separated pairs of the api key and its role, like key1:admin,key2:curator. The roles are reader, curator and admin")
+	flag.StringVar(&c.JWKSFile, "jwks_file", "", "The JWKS file the JWT bearer tokens are validated against; the tokens are not accepted when empty")
+	flag.StringVar(&c.JWTIssuer, "jwt_issuer", "", "The issuer the JWT bearer tokens must have; not checked when empty")
+	flag.StringVar(&c.JWTAudience, "jwt_audience", "", "The audience the JWT bearer tokens must have; not checked when empty")
+	flag.StringVar(&c.JWTRolesClaim, "jwt_roles_claim", "roles", "The JWT claim listing the roles of the client")
+	flag.StringVar(&c.AnonymousRole, "anonymous_role", "reader", "The role of the clients with no credentials; empty to require the credentials for every route")
+
+	flag.StringVar(&c.RateLimits, "rate_limits", "query=20/m:5,search=60/m:10,upload=60/h:10,ingest=60/h:10", "Comma separated per client token bucket limits of the routes, like query=20/m:5: the number of requests per s, m, h or d and the optional burst. The routes are query, search, upload, ingest, jobs and documents")
+	flag.BoolVar(&c.TrustProxyHeaders, "trust_proxy_headers", false, "Read the client address from the X-Forwarded-For headers of the load balancer")
+	flag.IntVar(&c.LLMDailyCalls, "llm_daily_calls", 300, "The llm calls a client can make a day (UTC) with the query and search requests; 0 for no limit")
+	flag.IntVar(&c.LLMDailyTokens, "llm_daily_tokens", 0, "The llm tokens a client can use a day (UTC) with the query and search requests; estimated from the text length when the provider does not report them; 0 for no limit")
 
 	flag.Parse()
 }
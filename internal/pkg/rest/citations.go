@@ -0,0 +1,39 @@
+package rest
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	log "github.com/sirupsen/logrus"
+)
+
+var citationRegexp = regexp.MustCompile(`\s?\[(\d+)\]`)
+
+// extractCitations maps the [n] markers of the answer to the pages the answer was generated from;
+// markers pointing to no page are removed from the answer
+func extractCitations(answer string, entries []model.SearchResultsEntry) (string, []model.Citation) {
+	citations := []model.Citation{}
+	cited := map[int]struct{}{}
+	answer = citationRegexp.ReplaceAllStringFunc(answer, func(marker string) string {
+		idx, err := strconv.Atoi(strings.Trim(strings.TrimSpace(marker), "[]"))
+		if err != nil || idx < 1 || idx > len(entries) {
+			log.Warnf("answer cites the missing page: '%s'", marker)
+			return ""
+		}
+		if _, ok := cited[idx]; !ok {
+			cited[idx] = struct{}{}
+			citations = append(citations, model.Citation{
+				Index:       idx,
+				Filename:    entries[idx-1].Filename,
+				Page:        entries[idx-1].Page,
+				Heading:     entries[idx-1].Heading,
+				PageContent: entries[idx-1].PageContent,
+				SourceURL:   entries[idx-1].SourceURL,
+			})
+		}
+		return marker
+	})
+	return answer, citations
+}
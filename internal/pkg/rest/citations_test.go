@@ -0,0 +1,68 @@
+package rest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+)
+
+func TestExtractCitations(t *testing.T) {
+	entries := []model.SearchResultsEntry{
+		{PageContent: "warming", Filename: "ar6.pdf", Page: 4, Heading: "A.1 Observed warming"},
+		{PageContent: "sea level", Filename: "climate.nasa.gov/evidence", SourceURL: "https://climate.nasa.gov/evidence"},
+	}
+
+	tests := []struct {
+		name      string
+		answer    string
+		cited     string
+		citations string
+	}{
+		{
+			name:      "no citations",
+			answer:    "The climate is warming.",
+			cited:     "The climate is warming.",
+			citations: "[]",
+		},
+		{
+			name:      "citations in the order of the first marker",
+			answer:    "The sea level is rising [2]. The climate is warming [1].",
+			cited:     "The sea level is rising [2]. The climate is warming [1].",
+			citations: "[2:climate.nasa.gov/evidence:0 1:ar6.pdf:4]",
+		},
+		{
+			name:      "repeated markers are cited once",
+			answer:    "The climate is warming [1][1]. It is unequivocal [1].",
+			cited:     "The climate is warming [1][1]. It is unequivocal [1].",
+			citations: "[1:ar6.pdf:4]",
+		},
+		{
+			name:      "unknown markers are removed",
+			answer:    "The climate is warming [1] [3]. The ice is melting [0].",
+			cited:     "The climate is warming [1]. The ice is melting.",
+			citations: "[1:ar6.pdf:4]",
+		},
+		{
+			name:      "only unknown markers",
+			answer:    "The ice is melting [7].",
+			cited:     "The ice is melting.",
+			citations: "[]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cited, citations := extractCitations(tt.answer, entries)
+			if cited != tt.cited {
+				t.Errorf("answer is '%s', want '%s'", cited, tt.cited)
+			}
+			summary := []string{}
+			for _, citation := range citations {
+				summary = append(summary, fmt.Sprintf("%d:%s:%d", citation.Index, citation.Filename, citation.Page))
+			}
+			if got := fmt.Sprint(summary); got != tt.citations {
+				t.Errorf("citations are '%s', want '%s'", got, tt.citations)
+			}
+		})
+	}
+}
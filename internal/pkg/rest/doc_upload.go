@@ -1,63 +1,121 @@
 package rest
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	"strconv"
 
-	"code.sajari.com/docconv"
 	"github.com/arkadyb/climate_mate/internal/pkg/app"
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
 	log "github.com/sirupsen/logrus"
 )
 
+// chunkingOptionsFromRequest overrides the defaults with the chunk_strategy, chunk_size, chunk_overlap and min_chunk_size form fields
+func chunkingOptionsFromRequest(r *http.Request, defaults model.ChunkingOptions) (model.ChunkingOptions, error) {
+	chunking := defaults
+	if strategy := r.FormValue("chunk_strategy"); len(strategy) > 0 {
+		chunking.Strategy = strategy
+	}
+	for field, value := range map[string]*int{
+		"chunk_size":     &chunking.ChunkSize,
+		"chunk_overlap":  &chunking.ChunkOverlap,
+		"min_chunk_size": &chunking.MinChunkSize,
+	} {
+		if param := r.FormValue(field); len(param) > 0 {
+			iVal, err := strconv.Atoi(param)
+			if err != nil {
+				return model.ChunkingOptions{}, fmt.Errorf("invalid %s", field)
+			}
+			*value = iVal
+		}
+	}
+	return chunking, nil
+}
+
+// tagsFromRequest reads the tags form field, a json object like {"publisher":"IPCC","year":2021}
+func tagsFromRequest(r *http.Request) (map[string]any, error) {
+	param := r.FormValue("tags")
+	if len(param) == 0 {
+		return nil, nil
+	}
+	tags := map[string]any{}
+	if err := json.Unmarshal([]byte(param), &tags); err != nil {
+		return nil, errors.New("invalid tags, a json object is expected")
+	}
+	return tags, nil
+}
+
+// uploadFormOverhead is the room left in the request body for the form fields next to the file
+const uploadFormOverhead int64 = 1 << 20
+
 func DocumentUploadEndpoint(a *app.App) http.Handler {
-	// NOTE: the endpoint is protected in the deployed app
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
+		// the file is kept in memory until it is queued, so the body is cut at the max upload size
+		maxUploadSize := a.MaxUploadSize()
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+uploadFormOverhead)
+		tooLarge := fmt.Sprintf("the file is larger than %d MiB", maxUploadSize>>20)
+
 		file, handler, err := r.FormFile("file")
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeMessage(w, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			io.WriteString(w, `{"message":"failed to read multipart form data"}`)
+			writeMessage(w, http.StatusBadRequest, "failed to read multipart form data")
 			return
 		}
 		defer file.Close()
+		if handler.Size > maxUploadSize {
+			writeMessage(w, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
 
+		// the summary is generated when missing
 		summary := r.FormValue("summary")
-		if len(summary) == 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			io.WriteString(w, `{"message":"missing summary"}`)
+
+		chunking, err := chunkingOptionsFromRequest(r, a.DefaultChunkingOptions())
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		docConvResponse, err := docconv.Convert(file, handler.Header["Content-Type"][0], true)
+		tags, err := tagsFromRequest(r)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, `{"message":"could parse file"}`)
-			log.Error(err)
+			writeMessage(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		if docConvResponse == nil || len(docConvResponse.Error) > 0 {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, fmt.Sprintf(`{"message":"failed to process file. Error: %s"}`, docConvResponse.Error))
+
+		content, err := io.ReadAll(file)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, "failed to read the file")
+			log.Error(err)
 			return
 		}
 
-		// index the summary into the base collection; index regardless of the size
-		err = a.IndexSummaryForFile(r.Context(), handler.Filename, strings.NewReader(summary))
+		// the file is converted and indexed in the background; the client follows the progress by the job id
+		job, err := a.EnqueueIngestion(r.Context(), handler.Filename, handler.Header.Get("Content-Type"), summary, content, chunking, tags)
+		if errors.Is(err, app.ErrInvalidChunkingOptions) || errors.Is(err, app.ErrInvalidTags) {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			io.WriteString(w, `{"message":"failed to index the document"}`)
+			writeMessage(w, http.StatusInternalServerError, "failed to queue the document")
 			return
 		}
 
-		// index the docs in the collection named same as file; index when the page size is at least 250 chars in length
-		err = a.IndexFile(r.Context(), handler.Filename, strings.NewReader(docConvResponse.Body))
+		jobJson, err := json.Marshal(job)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			io.WriteString(w, `{"message":"failed to index the document"}`)
+			writeMessage(w, http.StatusInternalServerError, "failed to process request")
+			log.Error(err)
 			return
 		}
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, string(jobJson))
 	})
 }
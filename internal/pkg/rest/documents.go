@@ -0,0 +1,83 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app"
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+func DocumentListEndpoint(application *app.App) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		documents, err := application.ListDocuments(r.Context())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, `{"message":"failed to list documents"}`)
+			return
+		}
+
+		documentsJson, err := json.Marshal(struct {
+			Documents []model.Document `json:"documents"`
+		}{documents})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, `{"message":"failed to process request"}`)
+			log.Error(err)
+			return
+		}
+		io.WriteString(w, string(documentsJson))
+	})
+}
+
+func DocumentGetEndpoint(application *app.App) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		document, err := application.GetDocument(r.Context(), mux.Vars(r)["name"])
+		if errors.Is(err, app.ErrDocumentNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"message":"document not found"}`)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, `{"message":"failed to get the document"}`)
+			return
+		}
+
+		documentJson, err := json.Marshal(document)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, `{"message":"failed to process request"}`)
+			log.Error(err)
+			return
+		}
+		io.WriteString(w, string(documentJson))
+	})
+}
+
+func DocumentDeleteEndpoint(application *app.App) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		err := application.DeleteDocument(r.Context(), mux.Vars(r)["name"])
+		if errors.Is(err, app.ErrDocumentNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"message":"document not found"}`)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, `{"message":"failed to delete the document"}`)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,39 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+func JobEndpoint(application *app.App) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		job, err := application.GetJob(r.Context(), mux.Vars(r)["id"])
+		if errors.Is(err, app.ErrJobNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"message":"job not found"}`)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, `{"message":"failed to get the job"}`)
+			return
+		}
+
+		jobJson, err := json.Marshal(job)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, `{"message":"failed to process request"}`)
+			log.Error(err)
+			return
+		}
+		io.WriteString(w, string(jobJson))
+	})
+}
@@ -0,0 +1,113 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app"
+)
+
+// searchStrategyFromRequest reads the searchby parameter into the options; falls back to the top first strategy.
+// The mmr strategy takes the optional lambda after the colon, like mmr:0.7
+func searchStrategyFromRequest(r *http.Request, opts *app.SearchOptions) error {
+	opts.Strategy = app.SearchStrategyTopFirst
+	searchStrategyParam, lambdaParam, hasLambda := strings.Cut(r.URL.Query().Get("searchby"), ":")
+	if len(searchStrategyParam) > 0 {
+		switch searchStrategyParam {
+		case "wide":
+			opts.Strategy = app.SearchStrategyWide
+		case "hybrid":
+			opts.Strategy = app.SearchStrategyHybrid
+		case "mmr":
+			opts.Strategy = app.SearchStrategyMMR
+			if hasLambda {
+				fVal, err := strconv.ParseFloat(lambdaParam, 32)
+				if err != nil {
+					return fmt.Errorf("invalid searchby lambda")
+				}
+				opts.MMRLambda = float32(fVal)
+			}
+		}
+	}
+	return nil
+}
+
+// searchOptionsFromRequest overrides the server defaults with the searchby, score_threshold, filter and the routing parameters
+func searchOptionsFromRequest(r *http.Request, application *app.App) (app.SearchOptions, error) {
+	opts := application.DefaultSearchOptions()
+	if err := searchStrategyFromRequest(r, &opts); err != nil {
+		return app.SearchOptions{}, err
+	}
+	if param := r.URL.Query().Get("score_threshold"); len(param) > 0 {
+		fVal, err := strconv.ParseFloat(param, 32)
+		if err != nil {
+			return app.SearchOptions{}, fmt.Errorf("invalid score_threshold")
+		}
+		opts.ScoreThreshold = float32(fVal)
+	}
+	for _, expression := range r.URL.Query()["filter"] {
+		filter, err := app.ParseMetadataFilter(expression)
+		if err != nil {
+			return app.SearchOptions{}, err
+		}
+		opts.Filters = append(opts.Filters, filter)
+	}
+	routing, err := routingOptionsFromRequest(r, opts.Routing, application.MaxSearchResults())
+	if err != nil {
+		return app.SearchOptions{}, err
+	}
+	opts.Routing = routing
+	return opts, app.ValidateSearchOptions(opts)
+}
+
+// pagesCountFromRequest reads the n parameter, the number of the pages to return, capped at max
+func pagesCountFromRequest(r *http.Request, defaultCount, max int) (int, error) {
+	count := defaultCount
+	if param := r.URL.Query().Get("n"); len(param) > 0 {
+		iVal, err := strconv.Atoi(param)
+		if err != nil || iVal < 1 {
+			return 0, fmt.Errorf("invalid n, a positive number is expected")
+		}
+		count = iVal
+	}
+	return min(count, max), nil
+}
+
+// routingOptionsFromRequest overrides the defaults with the routing_depth, routing_namespaces, routing_max_score and routing parameters;
+// routing=all searches every file. The routing namespaces are capped at maxNamespaces
+func routingOptionsFromRequest(r *http.Request, defaults app.RoutingOptions, maxNamespaces int) (app.RoutingOptions, error) {
+	routing := defaults
+	query := r.URL.Query()
+	switch query.Get("routing") {
+	case "":
+	case "all":
+		routing.All = true
+	case "summary":
+		routing.All = false
+	default:
+		return app.RoutingOptions{}, fmt.Errorf("invalid routing")
+	}
+	for field, value := range map[string]*int{
+		"routing_depth":      &routing.Depth,
+		"routing_namespaces": &routing.Namespaces,
+	} {
+		if param := query.Get(field); len(param) > 0 {
+			iVal, err := strconv.Atoi(param)
+			if err != nil {
+				return app.RoutingOptions{}, fmt.Errorf("invalid %s", field)
+			}
+			*value = iVal
+		}
+	}
+	if param := query.Get("routing_max_score"); len(param) > 0 {
+		fVal, err := strconv.ParseFloat(param, 32)
+		if err != nil {
+			return app.RoutingOptions{}, fmt.Errorf("invalid routing_max_score")
+		}
+		routing.MaxScore = float32(fVal)
+	}
+	routing.Namespaces = min(routing.Namespaces, maxNamespaces)
+	return routing, nil
+}
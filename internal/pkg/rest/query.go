@@ -1,113 +1,389 @@
 package rest
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/arkadyb/climate_mate/internal/pkg/app"
 	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
 	log "github.com/sirupsen/logrus"
 )
 
-const numberOfPagesToLook int = 10
+const (
+	numberOfPagesToLook int = 10
+	// with reranking on, the search looks into <rerankCandidatesFactor> times more pages and the answer uses only the top reranked ones
+	rerankCandidatesFactor      int = 3
+	numberOfRerankedPagesToLook int = 5
+
+	promptToRephrase     string = "Your query is too short or unclear. Please rephrase your question and try again."
+	dunnoAnswer          string = "I dont know."
+	globalAnswerPreamble string = "I couldn't locate an answer within our local knowledge base. Here's what the global knowledge base contains instead. "
+)
+
+// answerSink receives the answer as the query pipeline produces it; the JSON sink writes the whole answer at once, the SSE sink
+// streams every part to the client right away
+type answerSink interface {
+	// fail answers with the status and the message; the failures past the start are reported the way the sink streams
+	fail(status int, message string)
+	// start is called once the request is valid, ahead of any part of the answer
+	start(conversationID string) error
+	prompt(improvedPrompt string) error
+	sources(entries []model.SearchResultsEntry) error
+	// streams tells whether the answer tokens are handed over to token as the model produces them
+	streams() bool
+	token(token string) error
+	// done ends the answer; cached tells the answer was given to a similar question before
+	done(answer model.Answer, cached bool) error
+}
+
+// jsonAnswerSink writes the answer as a single JSON response
+type jsonAnswerSink struct {
+	w              http.ResponseWriter
+	conversationID string
+}
+
+func (s *jsonAnswerSink) fail(status int, message string) {
+	writeMessage(s.w, status, message)
+}
+
+func (s *jsonAnswerSink) start(conversationID string) error {
+	s.conversationID = conversationID
+	return nil
+}
+
+func (s *jsonAnswerSink) prompt(string) error { return nil }
+
+func (s *jsonAnswerSink) sources([]model.SearchResultsEntry) error { return nil }
+
+func (s *jsonAnswerSink) streams() bool { return false }
+
+func (s *jsonAnswerSink) token(string) error { return nil }
+
+func (s *jsonAnswerSink) done(answer model.Answer, cached bool) error {
+	writeQueryAnswer(s.w, s.conversationID, answer, cached)
+	return nil
+}
 
 func QueryEndpoint(application *app.App) http.Handler {
+	streamEndpoint := QueryStreamEndpoint(application)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*") //TODO: remove
-		// validate the request
-		query := r.URL.Query().Get("q")
-		if len(query) == 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			io.WriteString(w, `{"message":"missing query(q) parameter"}`)
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			streamEndpoint.ServeHTTP(w, r)
 			return
 		}
 
-		searchStrategy := app.SearchStrategyTopFirst
-		searchStrategyParam := r.URL.Query().Get("searchby")
-		if len(searchStrategyParam) > 0 {
-			switch searchStrategyParam {
-			case "wide":
-				searchStrategy = app.SearchStrategyWide
-				break
-			}
-		}
+		w.Header().Set("Content-Type", "application/json")
+		answerQuery(application, w, r, &jsonAnswerSink{w: w})
+	})
+}
 
-		promptToRephrase := "Your query is too short or unclear. Please rephrase your question and try again."
+// answerQuery is the query pipeline shared by the JSON and the streaming endpoints: the question, refined into a standalone prompt
+// with the conversation history, is answered from the found pages or from the global knowledge base when the pages do not have
+// the answer, and recorded as the conversation turn. The new questions similar to the ones asked before are answered from the cache
+func answerQuery(application *app.App, w http.ResponseWriter, r *http.Request, sink answerSink) {
+	ctx := r.Context()
+	w.Header().Set("Access-Control-Allow-Origin", "*") //TODO: remove
+	// validate the request
+	query := r.URL.Query().Get("q")
+	if len(query) == 0 {
+		sink.fail(http.StatusBadRequest, "missing query(q) parameter")
+		return
+	}
 
-		// Given the following user query and conversation log, formulate a question that would be the most relevant to provide the user with an answer from a knowledge base.\n\nCONVERSATION LOG: \n{conversation}\n\nQuery: {query}\n\nRefined Query:",
-		generatedPrompt, err := application.GenerateFromSinglePrompt(
-			ctx,
-			fmt.Sprintf(
-				`Generate a prompt for the user query that would be the most complete to provide the user with the answer from the knowledge base. User query: %s.
-				If the query is too short or unclear return '%s'. Return only the generated prompt.`, query, promptToRephrase),
-		)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, `{"message":"failed to process the query"}`)
+	searchOptions, err := searchOptionsFromRequest(r, application)
+	if err != nil {
+		sink.fail(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	conversationID, history, err := conversationFromRequest(ctx, application, r)
+	if errors.Is(err, app.ErrInvalidConversationID) {
+		sink.fail(http.StatusBadRequest, "invalid conversation_id parameter")
+		return
+	}
+	if err != nil {
+		sink.fail(http.StatusInternalServerError, "failed to load the conversation")
+		log.Error(err)
+		return
+	}
+
+	if err := sink.start(conversationID); err != nil {
+		log.Error(err)
+		return
+	}
+
+	cached, cacheKey := cachedAnswer(ctx, application, conversationID, query, history, searchOptions)
+	if cached != nil {
+		if err := sendAnswer(sink, *cached, true); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	generatedPrompt, err := refineQuery(ctx, application, query, history)
+	if err != nil {
+		sink.fail(http.StatusInternalServerError, "failed to process the query")
+		log.Error(err)
+		return
+	}
+	if generatedPrompt == promptToRephrase {
+		if err := sink.token(promptToRephrase); err != nil {
 			log.Error(err)
 			return
 		}
+		if err := sink.done(model.Answer{Answer: promptToRephrase}, false); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+	if err := sink.prompt(generatedPrompt); err != nil {
+		log.Error(err)
+		return
+	}
 
-		answer := struct {
-			Answer  string                     `json:"answer"`
-			Prompt  string                     `json:"improved_prompt,omitempty"`
-			Sources []model.SearchResultsEntry `json:"sources,omitempty"`
-		}{}
-		if generatedPrompt != promptToRephrase {
-			// search pageContents
-			searchResults, err := application.Search(r.Context(), generatedPrompt, numberOfPagesToLook, searchStrategy)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				io.WriteString(w, fmt.Sprintf(`{"message":"failed to find documents for query: '%s'"}`, query))
-				return
-			}
+	// search pageContents
+	searchResults, err := searchPages(ctx, application, generatedPrompt, answerPagesCount(application), searchOptions)
+	if err != nil {
+		sink.fail(http.StatusInternalServerError, fmt.Sprintf("failed to find documents for query: '%s'", query))
+		log.Error(err)
+		return
+	}
+	if err := sink.sources(searchResults.Entries); err != nil {
+		log.Error(err)
+		return
+	}
 
-			pages := []string{}
-			for _, entry := range searchResults.Entries {
-				pages = append(pages, entry.PageContent)
-			}
+	answer, err := generateAnswer(ctx, application, sink, generatedPrompt, searchResults.Entries)
+	if err != nil {
+		sink.fail(http.StatusInternalServerError, "failed to generate answer")
+		log.Error(err)
+		return
+	}
+
+	err = application.AddConversationTurn(ctx, conversationID, model.ConversationTurn{
+		Query:  query,
+		Prompt: generatedPrompt,
+		Answer: answer.Answer,
+	})
+	if err != nil {
+		log.Error(err)
+	}
+	if err := application.CacheAnswer(ctx, cacheKey, answer); err != nil {
+		log.Error(err)
+	}
+
+	if err := sink.done(answer, false); err != nil {
+		log.Error(err)
+	}
+}
 
-			dunnoAnswer := "I dont know."
-			prompts := append([]string{}, pages...)
-			prompts = append(prompts, fmt.Sprintf("Answer the question '%s' using the provided context. The answer should not exceed 500 characters. Do not add any formatting, new lines or the special characters. If its impossible to answer reply '%s'.", generatedPrompt, dunnoAnswer))
+// generateAnswer answers the prompt from the found pages, handing the tokens over to the sink when it streams; the answer comes from
+// the global knowledge base when the pages do not have it
+func generateAnswer(ctx context.Context, application *app.App, sink answerSink, generatedPrompt string, entries []model.SearchResultsEntry) (model.Answer, error) {
+	answer := model.Answer{
+		Prompt:  generatedPrompt,
+		Sources: entries,
+	}
 
-			answerResp, err := application.GenerateFromParts(ctx, prompts)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				io.WriteString(w, `{"message":"failed to generate answer"}`)
-				log.Error(err)
-				return
+	// hold the tokens back while the answer may still turn out to be the dunno answer
+	pending := ""
+	holding := true
+	var streamingFunc func(ctx context.Context, chunk []byte) error
+	if sink.streams() {
+		streamingFunc = func(ctx context.Context, chunk []byte) error {
+			if !holding {
+				return sink.token(string(chunk))
 			}
-			if answerResp == dunnoAnswer {
-				answerResp, err = application.GenerateFromSinglePrompt(ctx, fmt.Sprintf("Answer the user's question '%s'. Do not add any formatting, new lines or the special characters. If its impossible to answer explain the user why. The answer should not exceed 500 characters.", generatedPrompt))
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					io.WriteString(w, `{"message":"failed to generate answer"}`)
-					log.Error(err)
-					return
-				}
-				answerResp = fmt.Sprintf(`I couldn't locate an answer within our local knowledge base. Here's what the global knowledge base contains instead. %s`, answerResp)
+			pending += string(chunk)
+			if strings.HasPrefix(dunnoAnswer, strings.TrimSpace(pending)) {
+				return nil
 			}
-			answer.Answer = answerResp
-			answer.Prompt = generatedPrompt
-			answer.Sources = searchResults.Entries
-		} else {
-			answer.Answer = promptToRephrase
+			holding = false
+			return sink.token(pending)
 		}
+	}
 
-		answerJson, err := json.Marshal(answer)
+	// nothing cleared the score threshold, so the pages are not worth the answer generation
+	answerResp := dunnoAnswer
+	if len(entries) > 0 {
+		var err error
+		answerResp, err = application.GenerateFromPartsStream(ctx, answerPrompts(entries, generatedPrompt), streamingFunc)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, `{"message":"failed to construct the sponse"}`)
-			log.Error(err)
-			return
+			return model.Answer{}, err
+		}
+	}
+
+	if strings.TrimSpace(answerResp) == dunnoAnswer {
+		if err := sink.token(globalAnswerPreamble); err != nil {
+			return model.Answer{}, err
+		}
+		var globalStreamingFunc func(ctx context.Context, chunk []byte) error
+		if sink.streams() {
+			globalStreamingFunc = func(ctx context.Context, chunk []byte) error {
+				return sink.token(string(chunk))
+			}
 		}
+		globalAnswer, err := application.GenerateFromPartsStream(ctx, []string{globalAnswerPrompt(generatedPrompt)}, globalStreamingFunc)
+		if err != nil {
+			return model.Answer{}, err
+		}
+		answer.Answer = globalAnswerPreamble + globalAnswer
+		return answer, nil
+	}
+
+	if holding && len(pending) > 0 {
+		// the answer was a prefix of the dunno answer only
+		if err := sink.token(pending); err != nil {
+			return model.Answer{}, err
+		}
+	}
+	// the streamed tokens are out already, so the markers pointing to no page are only left out of the citations
+	answer.Answer, answer.Citations = extractCitations(answerResp, entries)
+	return answer, nil
+}
 
-		io.WriteString(w, string(answerJson))
-		w.WriteHeader(http.StatusOK)
+// sendAnswer hands the whole answer known beforehand over to the sink the way it is produced, the answer text as a single token;
+// cached tells the answer was given to a similar question before
+func sendAnswer(sink answerSink, answer model.Answer, cached bool) error {
+	if len(answer.Prompt) > 0 {
+		if err := sink.prompt(answer.Prompt); err != nil {
+			return err
+		}
+	}
+	if err := sink.sources(answer.Sources); err != nil {
+		return err
+	}
+	if err := sink.token(answer.Answer); err != nil {
+		return err
+	}
+	return sink.done(answer, cached)
+}
+
+// writeQueryAnswer writes the answer of the conversation; cached tells the answer was given to a similar question before
+func writeQueryAnswer(w http.ResponseWriter, conversationID string, answer model.Answer, cached bool) {
+	answerJson, err := json.Marshal(struct {
+		ConversationID string `json:"conversation_id"`
+		model.Answer
+		Cached bool `json:"cached,omitempty"`
+	}{
+		ConversationID: conversationID,
+		Answer:         answer,
+		Cached:         cached,
 	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, `{"message":"failed to construct the sponse"}`)
+		log.Error(err)
+		return
+	}
+
+	io.WriteString(w, string(answerJson))
+}
+
+// cachedAnswer looks for the answer given to a question similar to the new one and records it as the conversation turn; the follow up
+// questions are not looked up as they depend on the conversation. The key stores the answer to the question missing in the cache
+func cachedAnswer(ctx context.Context, application *app.App, conversationID, query string, history []model.ConversationTurn, searchOptions app.SearchOptions) (*model.Answer, app.AnswerCacheKey) {
+	if len(history) > 0 {
+		return nil, app.AnswerCacheKey{}
+	}
+
+	cached, key, err := application.CachedAnswer(ctx, query, searchOptions)
+	if err != nil {
+		log.Error(err)
+	}
+	if cached != nil {
+		err = application.AddConversationTurn(ctx, conversationID, model.ConversationTurn{
+			Query:  query,
+			Prompt: cached.Prompt,
+			Answer: cached.Answer,
+		})
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	return cached, key
+}
+
+// conversationFromRequest reads the conversation_id parameter and loads its history; starts a new conversation when the parameter is missing
+func conversationFromRequest(ctx context.Context, application *app.App, r *http.Request) (string, []model.ConversationTurn, error) {
+	conversationID := r.URL.Query().Get("conversation_id")
+	if len(conversationID) == 0 {
+		return app.NewConversationID(), []model.ConversationTurn{}, nil
+	}
+
+	history, err := application.ConversationHistory(ctx, conversationID)
+	if err != nil {
+		return "", nil, err
+	}
+	return conversationID, history, nil
+}
+
+// searchPages searches the knowledge base; with reranking on, a wider set of candidates is searched and only the top reranked
+// numDocuments are kept
+func searchPages(ctx context.Context, application *app.App, query string, numDocuments int, searchOptions app.SearchOptions) (model.SearchResults, error) {
+	if !application.RerankEnabled() {
+		return application.Search(ctx, query, numDocuments, searchOptions)
+	}
+
+	candidates, err := application.Search(ctx, query, numDocuments*rerankCandidatesFactor, searchOptions)
+	if err != nil {
+		return model.SearchResults{}, err
+	}
+	return application.Rerank(ctx, query, candidates, numDocuments)
+}
+
+// answerPagesCount is the number of pages fed into the answer
+func answerPagesCount(application *app.App) int {
+	if application.RerankEnabled() {
+		return numberOfRerankedPagesToLook
+	}
+	return numberOfPagesToLook
+}
+
+// refineQuery asks the llm to turn the user query into a prompt complete enough to search the knowledge base with;
+// follow up questions are rewritten into standalone ones using the conversation history.
+// Returns promptToRephrase when the query is too short or unclear
+func refineQuery(ctx context.Context, application *app.App, query string, history []model.ConversationTurn) (string, error) {
+	if len(history) > 0 {
+		conversation := []string{}
+		for _, turn := range history {
+			conversation = append(conversation, fmt.Sprintf("User: %s\nAssistant: %s", turn.Query, turn.Answer))
+		}
+		return application.GenerateFromSinglePrompt(
+			ctx,
+			fmt.Sprintf(
+				`Given the following user query and conversation log, formulate a standalone question that would be the most relevant to provide the user with an answer from a knowledge base.
+				Resolve any reference to the earlier questions or answers so the question can be understood without the conversation log.
+				CONVERSATION LOG:
+				%s
+				Query: %s.
+				If the query is too short or unclear return '%s'. Return only the refined question.`, strings.Join(conversation, "\n"), query, promptToRephrase),
+		)
+	}
+
+	return application.GenerateFromSinglePrompt(
+		ctx,
+		fmt.Sprintf(
+			`Generate a prompt for the user query that would be the most complete to provide the user with the answer from the knowledge base. User query: %s.
+				If the query is too short or unclear return '%s'. Return only the generated prompt.`, query, promptToRephrase),
+	)
+}
+
+// answerPrompts puts the numbered found pages ahead of the instruction to answer the generated prompt from them citing the pages
+func answerPrompts(entries []model.SearchResultsEntry, generatedPrompt string) []string {
+	prompts := []string{}
+	for i, entry := range entries {
+		prompts = append(prompts, fmt.Sprintf("[%d] %s", i+1, entry.PageContent))
+	}
+	return append(prompts, fmt.Sprintf("Answer the question '%s' using the provided context. The answer should not exceed 500 characters. Support every claim with the number of the context passage it comes from in square brackets, for example [1] or [2]. Do not add any formatting, new lines or the special characters other than the passage numbers. If its impossible to answer reply '%s'.", generatedPrompt, dunnoAnswer))
+}
+
+// globalAnswerPrompt is used when the local knowledge base has no answer
+func globalAnswerPrompt(generatedPrompt string) string {
+	return fmt.Sprintf("Answer the user's question '%s'. Do not add any formatting, new lines or the special characters. If its impossible to answer explain the user why. The answer should not exceed 500 characters.", generatedPrompt)
 }
@@ -0,0 +1,104 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app"
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	eventConversation string = "conversation"
+	eventPrompt       string = "prompt"
+	eventSources      string = "sources"
+	eventToken        string = "token"
+	eventCitations    string = "citations"
+	eventDone         string = "done"
+	eventError        string = "error"
+)
+
+// sseWriter writes Server-Sent Events and flushes each one to the client right away; it is the streaming answer sink
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+func (s *sseWriter) send(event string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// fail answers with the status until the stream starts and with an error event after
+func (s *sseWriter) fail(status int, message string) {
+	if !s.started {
+		s.w.Header().Set("Content-Type", "application/json")
+		writeMessage(s.w, status, message)
+		return
+	}
+	if err := s.send(eventError, map[string]string{"message": message}); err != nil {
+		log.Error(err)
+	}
+}
+
+func (s *sseWriter) start(conversationID string) error {
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.Header().Set("Connection", "keep-alive")
+	s.w.WriteHeader(http.StatusOK)
+	s.started = true
+	return s.send(eventConversation, map[string]string{"conversation_id": conversationID})
+}
+
+func (s *sseWriter) prompt(improvedPrompt string) error {
+	return s.send(eventPrompt, map[string]string{"improved_prompt": improvedPrompt})
+}
+
+func (s *sseWriter) sources(entries []model.SearchResultsEntry) error {
+	return s.send(eventSources, entries)
+}
+
+func (s *sseWriter) streams() bool { return true }
+
+func (s *sseWriter) token(token string) error {
+	return s.send(eventToken, map[string]string{"token": token})
+}
+
+// done sends the citations, the tokens being out already, and closes the stream
+func (s *sseWriter) done(answer model.Answer, cached bool) error {
+	if len(answer.Citations) > 0 {
+		if err := s.send(eventCitations, answer.Citations); err != nil {
+			return err
+		}
+	}
+	return s.send(eventDone, struct {
+		Cached bool `json:"cached,omitempty"`
+	}{Cached: cached})
+}
+
+// QueryStreamEndpoint answers the same way QueryEndpoint does, but streams the improved prompt, the sources and then the answer tokens
+// as Server-Sent Events while they are produced
+func QueryStreamEndpoint(application *app.App) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, `{"message":"streaming is not supported"}`)
+			return
+		}
+
+		answerQuery(application, w, r, &sseWriter{w: w, flusher: flusher})
+	})
+}
@@ -0,0 +1,13 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// writeMessage answers with the status and the message; the message is escaped as it may echo the request parameters
+func writeMessage(w http.ResponseWriter, status int, message string) {
+	messageJson, _ := json.Marshal(map[string]string{"message": message})
+	w.WriteHeader(status)
+	w.Write(messageJson)
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"strconv"
 
 	"github.com/arkadyb/climate_mate/internal/pkg/app"
 	log "github.com/sirupsen/logrus"
@@ -22,28 +21,23 @@ func DocumentSearchEndpoint(application *app.App) http.Handler {
 			return
 		}
 
-		numDocuments := numberOfPagesToLook
-		numOfDocsToRetrieveParam := r.URL.Query().Get("n")
-		if len(numOfDocsToRetrieveParam) > 0 {
-			if iVal, err := strconv.Atoi(numOfDocsToRetrieveParam); err == nil {
-				numDocuments = iVal
-			}
+		numDocuments, err := pagesCountFromRequest(r, numberOfPagesToLook, application.MaxSearchResults())
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
 		}
 
-		searchStrategy := app.SearchStrategyTopFirst
-		searchStrategyParam := r.URL.Query().Get("searchby")
-		if len(searchStrategyParam) > 0 {
-			switch searchStrategyParam {
-			case "wide":
-				searchStrategy = app.SearchStrategyWide
-			}
+		searchOptions, err := searchOptionsFromRequest(r, application)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
 		}
 
 		// search pages
-		pages, err := application.Search(r.Context(), query, numDocuments, searchStrategy)
+		pages, err := searchPages(r.Context(), application, query, numDocuments, searchOptions)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, fmt.Sprintf(`{"message":"failed to find documents for query: '%s'"}`, query))
+			writeMessage(w, http.StatusInternalServerError, fmt.Sprintf("failed to find documents for query: '%s'", query))
+			log.Error(err)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
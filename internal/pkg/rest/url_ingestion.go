@@ -0,0 +1,71 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app"
+	"github.com/arkadyb/climate_mate/internal/pkg/app/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// URLIngestionEndpoint queues the page at the `url` form field, or every page listed in the `sitemap`, for ingestion
+func URLIngestionEndpoint(a *app.App) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		pageURL := r.FormValue("url")
+		sitemapURL := r.FormValue("sitemap")
+		if (len(pageURL) == 0) == (len(sitemapURL) == 0) {
+			writeMessage(w, http.StatusBadRequest, "either url or sitemap is required")
+			return
+		}
+
+		chunking, err := chunkingOptionsFromRequest(r, a.DefaultChunkingOptions())
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		tags, err := tagsFromRequest(r)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// the summary is optional, it is generated when missing; the pages of a sitemap get a summary each
+		summary := r.FormValue("summary")
+		if len(sitemapURL) > 0 && len(summary) > 0 {
+			writeMessage(w, http.StatusBadRequest, "summary can not be given with sitemap, each page gets its own generated summary")
+			return
+		}
+		jobs := []model.Job{}
+		if len(pageURL) > 0 {
+			var job model.Job
+			job, err = a.EnqueueURLIngestion(r.Context(), pageURL, summary, chunking, tags)
+			jobs = append(jobs, job)
+		} else {
+			jobs, err = a.EnqueueSitemapIngestion(r.Context(), sitemapURL, chunking, tags)
+		}
+		if errors.Is(err, app.ErrInvalidChunkingOptions) || errors.Is(err, app.ErrInvalidTags) || errors.Is(err, app.ErrInvalidURL) ||
+			errors.Is(err, app.ErrFetchFailed) || errors.Is(err, app.ErrEmptySitemap) {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "failed to queue the documents")
+			return
+		}
+
+		jobsJson, err := json.Marshal(map[string][]model.Job{"jobs": jobs})
+		if err != nil {
+			writeMessage(w, http.StatusInternalServerError, "failed to process request")
+			log.Error(err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, string(jobsJson))
+	})
+}
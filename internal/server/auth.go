@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/MicahParks/keyfunc/v2"
+	"github.com/golang-jwt/jwt/v5"
+	log "github.com/sirupsen/logrus"
+)
+
+// Role grants the access to the routes; every role is granted the access of the roles below it
+type Role int
+
+const (
+	RoleNone Role = iota
+	// queries and searches the documents
+	RoleReader
+	// uploads the documents
+	RoleCurator
+	// manages the indexed documents
+	RoleAdmin
+)
+
+var roleNames = map[string]Role{
+	"reader":  RoleReader,
+	"curator": RoleCurator,
+	"admin":   RoleAdmin,
+}
+
+// ParseRole reads the role name; the empty name is no role
+func ParseRole(name string) (Role, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if len(name) == 0 {
+		return RoleNone, nil
+	}
+	role, ok := roleNames[name]
+	if !ok {
+		return RoleNone, fmt.Errorf("unknown role '%s'", name)
+	}
+	return role, nil
+}
+
+// AuthOptions configures the clients authentication
+type AuthOptions struct {
+	// comma separated pairs of the api key and its role, like `key1:admin,key2:reader`
+	APIKeys string
+	// the JWKS file the JWT bearer tokens are validated against; the tokens are not accepted when empty
+	JWKSFile string
+	// the issuer and the audience the tokens must have; not checked when empty
+	JWTIssuer   string
+	JWTAudience string
+	// the token claim listing the roles, either a list or a space separated string
+	RolesClaim string
+	// the role of the requests with no credentials; empty to require the credentials for every route
+	AnonymousRole string
+}
+
+// Principal is the authenticated client
+type Principal struct {
+	// the api key hash prefix or the token subject
+	ID   string
+	Role Role
+}
+
+type principalContextKey struct{}
+
+// PrincipalFromContext returns the client authenticated for the request
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(Principal)
+	return principal, ok
+}
+
+// Authenticator checks the api keys and the JWT bearer tokens of the requests
+type Authenticator struct {
+	// the roles by the sha256 of the api keys, so the lookup does not leak the keys timing
+	apiKeys       map[string]Role
+	jwks          *keyfunc.JWKS
+	parser        *jwt.Parser
+	rolesClaim    string
+	anonymousRole Role
+}
+
+func NewAuthenticator(opts AuthOptions) (*Authenticator, error) {
+	auth := &Authenticator{
+		apiKeys:    map[string]Role{},
+		rolesClaim: opts.RolesClaim,
+	}
+
+	var err error
+	if auth.anonymousRole, err = ParseRole(opts.AnonymousRole); err != nil {
+		return nil, fmt.Errorf("invalid anonymous role: %w", err)
+	}
+
+	for _, pair := range strings.Split(opts.APIKeys, ",") {
+		if len(strings.TrimSpace(pair)) == 0 {
+			continue
+		}
+		key, roleName, found := strings.Cut(pair, ":")
+		key = strings.TrimSpace(key)
+		role, err := ParseRole(roleName)
+		if !found || len(key) == 0 || err != nil || role == RoleNone {
+			return nil, errors.New("invalid api keys, `key:role` pairs separated by commas are expected")
+		}
+		auth.apiKeys[hashAPIKey(key)] = role
+	}
+
+	if len(opts.JWKSFile) > 0 {
+		jwksJSON, err := os.ReadFile(opts.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		if auth.jwks, err = keyfunc.NewJSON(jwksJSON); err != nil {
+			return nil, fmt.Errorf("invalid jwks file: %w", err)
+		}
+		parserOptions := []jwt.ParserOption{
+			jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+			jwt.WithExpirationRequired(),
+		}
+		if len(opts.JWTIssuer) > 0 {
+			parserOptions = append(parserOptions, jwt.WithIssuer(opts.JWTIssuer))
+		}
+		if len(opts.JWTAudience) > 0 {
+			parserOptions = append(parserOptions, jwt.WithAudience(opts.JWTAudience))
+		}
+		auth.parser = jwt.NewParser(parserOptions...)
+	}
+
+	if len(auth.apiKeys) == 0 && auth.jwks == nil {
+		log.Warn("no api keys nor jwks configured, only the routes open to the anonymous role are served")
+	}
+	return auth, nil
+}
+
+// Require lets the requests of the clients having the role or a higher one through; the requests with no or invalid
+// credentials are answered with 401 and the clients lacking the role with 403
+func (auth *Authenticator) Require(role Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := auth.authenticate(r)
+		// the anonymous clients are asked for the credentials, the authenticated ones lack the role
+		if err != nil || (len(principal.ID) == 0 && principal.Role < role) {
+			if err != nil {
+				log.Warnf("authentication failed: %s", err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"message":"unauthorized"}`)
+			return
+		}
+		if principal.Role < role {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `{"message":"forbidden"}`)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal)))
+	})
+}
+
+// authenticate reads the api key from the X-API-Key header or either the api key or the JWT from the bearer authorization;
+// the requests with no credentials get the anonymous role
+func (auth *Authenticator) authenticate(r *http.Request) (Principal, error) {
+	credentials := r.Header.Get("X-API-Key")
+	if authorization := r.Header.Get("Authorization"); len(credentials) == 0 && len(authorization) > 0 {
+		scheme, token, found := strings.Cut(authorization, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return Principal{}, errors.New("unsupported authorization scheme")
+		}
+		credentials = strings.TrimSpace(token)
+	}
+	if len(credentials) == 0 {
+		return Principal{Role: auth.anonymousRole}, nil
+	}
+
+	keyHash := hashAPIKey(credentials)
+	if role, ok := auth.apiKeys[keyHash]; ok {
+		return Principal{ID: "key:" + keyHash[:12], Role: role}, nil
+	}
+	if auth.jwks == nil {
+		return Principal{}, errors.New("unknown api key")
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := auth.parser.ParseWithClaims(credentials, claims, auth.jwks.Keyfunc); err != nil {
+		return Principal{}, err
+	}
+	subject, _ := claims.GetSubject()
+	return Principal{ID: "sub:" + subject, Role: auth.tokenRole(claims)}, nil
+}
+
+// tokenRole returns the highest of the known roles the token lists
+func (auth *Authenticator) tokenRole(claims jwt.MapClaims) Role {
+	names := []string{}
+	switch v := claims[auth.rolesClaim].(type) {
+	case string:
+		names = strings.Fields(v)
+	case []any:
+		for _, name := range v {
+			if s, ok := name.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+
+	highest := RoleNone
+	for _, name := range names {
+		if role, err := ParseRole(name); err == nil && role > highest {
+			highest = role
+		}
+	}
+	return highest
+}
+
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
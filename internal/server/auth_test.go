@@ -0,0 +1,153 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testKeyID = "test-key"
+
+// newTestAuthenticator configures a key per role and a JWKS file with the public key of the returned private key
+func newTestAuthenticator(t *testing.T) (*Authenticator, *rsa.PrivateKey) {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": testKeyID,
+		"alg": "RS256",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes()),
+	}}})
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksFile, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := NewAuthenticator(AuthOptions{
+		APIKeys:       "reader-key:reader,curator-key:curator,admin-key:admin",
+		JWKSFile:      jwksFile,
+		JWTIssuer:     "https://issuer.example",
+		RolesClaim:    "roles",
+		AnonymousRole: "reader",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth, privateKey
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, expiresAt time.Time, roles ...string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub":   "user-1",
+		"iss":   "https://issuer.example",
+		"exp":   expiresAt.Unix(),
+		"roles": roles,
+	})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestRequire(t *testing.T) {
+	auth, key := newTestAuthenticator(t)
+	hour := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		role    Role
+		headers map[string]string
+		status  int
+	}{
+		{name: "missing header on a reader route", role: RoleReader, status: http.StatusOK},
+		{name: "missing header on a curator route", role: RoleCurator, status: http.StatusUnauthorized},
+		{name: "unknown key", role: RoleReader, headers: map[string]string{"X-API-Key": "unknown"}, status: http.StatusUnauthorized},
+		{name: "unsupported scheme", role: RoleReader, headers: map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}, status: http.StatusUnauthorized},
+		{name: "reader on a curator route", role: RoleCurator, headers: map[string]string{"X-API-Key": "reader-key"}, status: http.StatusForbidden},
+		{name: "curator on a curator route", role: RoleCurator, headers: map[string]string{"X-API-Key": "curator-key"}, status: http.StatusOK},
+		{name: "bearer api key", role: RoleAdmin, headers: map[string]string{"Authorization": "Bearer admin-key"}, status: http.StatusOK},
+		{name: "valid jwt", role: RoleCurator, headers: map[string]string{"Authorization": "Bearer " + signToken(t, key, testKeyID, hour, "reader", "curator")}, status: http.StatusOK},
+		{name: "jwt lacking the role", role: RoleAdmin, headers: map[string]string{"Authorization": "Bearer " + signToken(t, key, testKeyID, hour, "curator")}, status: http.StatusForbidden},
+		{name: "expired jwt", role: RoleReader, headers: map[string]string{"Authorization": "Bearer " + signToken(t, key, testKeyID, time.Now().Add(-time.Hour), "admin")}, status: http.StatusUnauthorized},
+		{name: "wrong kid jwt", role: RoleReader, headers: map[string]string{"Authorization": "Bearer " + signToken(t, key, "other-key", hour, "admin")}, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := auth.Require(tt.role, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, ok := PrincipalFromContext(r.Context()); !ok {
+					t.Error("principal is not set")
+				}
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for header, value := range tt.headers {
+				r.Header.Set(header, value)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status is '%d', want '%d'", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	auth, _ := newTestAuthenticator(t)
+	limiter, err := NewRateLimiter(nil, RateLimitOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the requests are rejected before they reach the endpoints, so no app is needed
+	router := newRouter("test", nil, auth, limiter)
+
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{method: http.MethodGet, path: "/v1/documents"},
+		{method: http.MethodGet, path: "/v1/documents/report.pdf"},
+		{method: http.MethodDelete, path: "/v1/documents/report.pdf"},
+	}
+	clients := []struct {
+		name   string
+		apiKey string
+		status int
+	}{
+		{name: "anonymous", status: http.StatusUnauthorized},
+		{name: "reader", apiKey: "reader-key", status: http.StatusForbidden},
+		{name: "curator", apiKey: "curator-key", status: http.StatusForbidden},
+	}
+	for _, route := range routes {
+		for _, client := range clients {
+			t.Run(route.method+" "+route.path+" "+client.name, func(t *testing.T) {
+				r := httptest.NewRequest(route.method, route.path, nil)
+				if len(client.apiKey) > 0 {
+					r.Header.Set("X-API-Key", client.apiKey)
+				}
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, r)
+				if w.Code != client.status {
+					t.Errorf("status is '%d', want '%d'", w.Code, client.status)
+				}
+			})
+		}
+	}
+}
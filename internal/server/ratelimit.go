@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arkadyb/climate_mate/internal/pkg/app"
+	"golang.org/x/time/rate"
+)
+
+// how often the limiters of the idle clients are dropped
+const rateLimiterSweepInterval = 10 * time.Minute
+
+var rateLimitUnits = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+}
+
+// RateLimitOptions configures the per client limits of the routes
+type RateLimitOptions struct {
+	// comma separated token bucket limits of the routes, like `query=20/m:5,upload=60/h`: the number of requests per second,
+	// minute, hour or day and the optional burst, which is the number of requests by default
+	Limits string
+	// read the client address from the X-Forwarded-For headers set by the load balancer
+	TrustProxyHeaders bool
+}
+
+type rateLimit struct {
+	// the time a token is added to the bucket in
+	every time.Duration
+	burst int
+}
+
+type clientLimiter struct {
+	limiter *rate.Limiter
+	// the time the empty bucket takes to refill
+	refill   time.Duration
+	lastSeen time.Time
+}
+
+// RateLimiter keeps a token bucket per route and client, the client being the authenticated principal or the address;
+// the buckets are kept in memory
+type RateLimiter struct {
+	app       *app.App
+	limits    map[string]rateLimit
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+func NewRateLimiter(application *app.App, opts RateLimitOptions) (*RateLimiter, error) {
+	limits, err := parseRateLimits(opts.Limits)
+	if err != nil {
+		return nil, err
+	}
+	return &RateLimiter{
+		app:       application,
+		limits:    limits,
+		clients:   map[string]*clientLimiter{},
+		lastSweep: time.Now(),
+	}, nil
+}
+
+// parseRateLimits reads the `route=number/unit:burst` limits
+func parseRateLimits(limitsString string) (map[string]rateLimit, error) {
+	limits := map[string]rateLimit{}
+	for _, limitString := range strings.Split(limitsString, ",") {
+		if len(strings.TrimSpace(limitString)) == 0 {
+			continue
+		}
+		invalidErr := fmt.Errorf("invalid rate limit '%s', `route=number/unit:burst` is expected", limitString)
+
+		route, value, found := strings.Cut(strings.TrimSpace(limitString), "=")
+		if !found {
+			return nil, invalidErr
+		}
+		value, burstString, hasBurst := strings.Cut(value, ":")
+		numberString, unitString, found := strings.Cut(value, "/")
+		number, err := strconv.Atoi(numberString)
+		unit, ok := rateLimitUnits[unitString]
+		if !found || err != nil || number <= 0 || !ok {
+			return nil, invalidErr
+		}
+		limit := rateLimit{every: unit / time.Duration(number), burst: number}
+		if hasBurst {
+			if limit.burst, err = strconv.Atoi(burstString); err != nil || limit.burst <= 0 {
+				return nil, invalidErr
+			}
+		}
+		limits[route] = limit
+	}
+	return limits, nil
+}
+
+// Limit answers the requests of the clients exceeding the route limit with 429; the routes with no limit configured are not limited
+func (l *RateLimiter) Limit(route string, next http.Handler) http.Handler {
+	limit, ok := l.limits[route]
+	if !ok {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reservation := l.clientLimiter(route, clientKey(r), limit).Reserve()
+		if delay := reservation.Delay(); delay > 0 {
+			reservation.Cancel()
+			tooManyRequests(w, "rate limit exceeded", delay)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LLMBudget answers the requests of the clients who have used up the daily llm budget with 429 and charges the llm calls made
+// serving the request to the client
+func (l *RateLimiter) LLMBudget(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := clientKey(r)
+		if retryAfter, exceeded := l.app.LLMBudgetExceeded(client); exceeded {
+			tooManyRequests(w, "daily llm budget exceeded", retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(app.WithLLMClient(r.Context(), client)))
+	})
+}
+
+func (l *RateLimiter) clientLimiter(route, client string, limit rateLimit) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > rateLimiterSweepInterval {
+		// the bucket of a client idle for longer than it takes to refill is full, so it is the same as a new one
+		for key, client := range l.clients {
+			if now.Sub(client.lastSeen) > client.refill {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	key := route + "|" + client
+	limiter, ok := l.clients[key]
+	if !ok {
+		limiter = &clientLimiter{
+			limiter: rate.NewLimiter(rate.Every(limit.every), limit.burst),
+			refill:  limit.every * time.Duration(limit.burst),
+		}
+		l.clients[key] = limiter
+	}
+	limiter.lastSeen = now
+	return limiter.limiter
+}
+
+// clientKey identifies the client by the authenticated principal and by the address otherwise
+func clientKey(r *http.Request) string {
+	if principal, ok := PrincipalFromContext(r.Context()); ok && len(principal.ID) > 0 {
+		return principal.ID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func tooManyRequests(w http.ResponseWriter, message string, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	messageJson, _ := json.Marshal(struct {
+		Message    string `json:"message"`
+		RetryAfter int    `json:"retry_after"`
+	}{
+		Message:    message,
+		RetryAfter: seconds,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(messageJson)
+}
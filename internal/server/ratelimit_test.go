@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	tests := []struct {
+		limits string
+		parsed map[string]rateLimit
+		err    bool
+	}{
+		{limits: "", parsed: map[string]rateLimit{}},
+		{limits: "query=20/m", parsed: map[string]rateLimit{"query": {every: 3 * time.Second, burst: 20}}},
+		{limits: "query=20/m:5, upload=2/h:1", parsed: map[string]rateLimit{
+			"query":  {every: 3 * time.Second, burst: 5},
+			"upload": {every: 30 * time.Minute, burst: 1},
+		}},
+		{limits: "search=10/s,,jobs=1/d", parsed: map[string]rateLimit{
+			"search": {every: 100 * time.Millisecond, burst: 10},
+			"jobs":   {every: 24 * time.Hour, burst: 1},
+		}},
+		{limits: "query", err: true},
+		{limits: "query=20", err: true},
+		{limits: "query=20/w", err: true},
+		{limits: "query=0/m", err: true},
+		{limits: "query=x/m", err: true},
+		{limits: "query=20/m:0", err: true},
+		{limits: "query=20/m:x", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.limits, func(t *testing.T) {
+			parsed, err := parseRateLimits(tt.limits)
+			if (err != nil) != tt.err {
+				t.Fatalf("error is '%v', want error '%v'", err, tt.err)
+			}
+			if len(parsed) != len(tt.parsed) {
+				t.Fatalf("limits are '%v', want '%v'", parsed, tt.parsed)
+			}
+			for route, limit := range tt.parsed {
+				if parsed[route] != limit {
+					t.Errorf("limit of '%s' is '%v', want '%v'", route, parsed[route], limit)
+				}
+			}
+		})
+	}
+}
+
+func TestLimit(t *testing.T) {
+	limiter, err := NewRateLimiter(nil, RateLimitOptions{Limits: "query=2/h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	limited := limiter.Limit("query", ok)
+	unlimited := limiter.Limit("search", ok)
+
+	request := func(handler http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := request(limited, "192.0.2.1:1000"); w.Code != http.StatusOK {
+			t.Fatalf("status of the request %d within the burst is '%d', want '%d'", i+1, w.Code, http.StatusOK)
+		}
+	}
+	// the client port changes between the connections
+	w := request(limited, "192.0.2.1:2000")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status over the limit is '%d', want '%d'", w.Code, http.StatusTooManyRequests)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "1800" {
+		t.Errorf("retry after is '%s', want '1800'", retryAfter)
+	}
+	if w := request(limited, "192.0.2.2:1000"); w.Code != http.StatusOK {
+		t.Errorf("status of another client is '%d', want '%d'", w.Code, http.StatusOK)
+	}
+	for i := 0; i < 3; i++ {
+		if w := request(unlimited, "192.0.2.1:1000"); w.Code != http.StatusOK {
+			t.Errorf("status of the route with no limit is '%d', want '%d'", w.Code, http.StatusOK)
+		}
+	}
+}
@@ -22,7 +22,35 @@ func NewServer(
 	version string,
 	port string,
 	app *app.App,
+	authOptions AuthOptions,
+	rateLimitOptions RateLimitOptions,
 ) *Server {
+	auth, err := NewAuthenticator(authOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+	limiter, err := NewRateLimiter(app, rateLimitOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	router := newRouter(version, app, auth, limiter)
+
+	handler := handlers.LoggingHandler(log.StandardLogger().Writer(), router)
+	if rateLimitOptions.TrustProxyHeaders {
+		handler = handlers.ProxyHeaders(handler)
+	}
+
+	return &Server{
+		Server: &http.Server{
+			Addr:    fmt.Sprintf(":%s", port),
+			Handler: handler,
+		},
+	}
+}
+
+// newRouter routes the requests to the endpoints guarded by the role and the limits of the route
+func newRouter(version string, app *app.App, auth *Authenticator, limiter *RateLimiter) *mux.Router {
 	router := mux.NewRouter()
 	// health endpoint
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -36,26 +64,40 @@ func NewServer(
 		fmt.Fprintf(w, `{ "version": "%s" }`, version)
 	}).Methods("GET")
 
+	// the readers query the documents, the curators upload them and the admins manage the indexed ones;
+	// the routes are limited per client and the ones calling the llm are charged to the client daily llm budget
 	versionRouter := router.PathPrefix("/v1").Subrouter()
 	versionRouter.Handle("/upload",
-		rest.DocumentUploadEndpoint(app),
+		auth.Require(RoleCurator, limiter.Limit("upload", rest.DocumentUploadEndpoint(app))),
+	).Methods("POST")
+	versionRouter.Handle("/ingest/url",
+		auth.Require(RoleCurator, limiter.Limit("ingest", rest.URLIngestionEndpoint(app))),
 	).Methods("POST")
+	versionRouter.Handle("/jobs/{id}",
+		auth.Require(RoleCurator, limiter.Limit("jobs", rest.JobEndpoint(app))),
+	).Methods("GET")
+	versionRouter.Handle("/documents",
+		auth.Require(RoleAdmin, limiter.Limit("documents", rest.DocumentListEndpoint(app))),
+	).Methods("GET")
+	versionRouter.Handle("/documents/{name:.+}",
+		auth.Require(RoleAdmin, limiter.Limit("documents", rest.DocumentGetEndpoint(app))),
+	).Methods("GET")
+	versionRouter.Handle("/documents/{name:.+}",
+		auth.Require(RoleAdmin, limiter.Limit("documents", rest.DocumentDeleteEndpoint(app))),
+	).Methods("DELETE")
 	versionRouter.Handle("/search",
-		rest.DocumentSearchEndpoint(app),
+		auth.Require(RoleReader, limiter.Limit("search", limiter.LLMBudget(rest.DocumentSearchEndpoint(app)))),
 	).Methods("GET")
 	versionRouter.Handle("/query",
-		rest.QueryEndpoint(app),
+		auth.Require(RoleReader, limiter.Limit("query", limiter.LLMBudget(rest.QueryEndpoint(app)))),
+	).Methods("GET")
+	versionRouter.Handle("/query/stream",
+		auth.Require(RoleReader, limiter.Limit("query", limiter.LLMBudget(rest.QueryStreamEndpoint(app)))),
 	).Methods("GET")
 
 	// default landing page
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./www"))).Methods("GET")
-
-	return &Server{
-		Server: &http.Server{
-			Addr:    fmt.Sprintf(":%s", port),
-			Handler: handlers.LoggingHandler(log.StandardLogger().Writer(), router),
-		},
-	}
+	return router
 }
 
 func (s *Server) Stop() {